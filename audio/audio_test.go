@@ -0,0 +1,83 @@
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+const testRate = 22050
+
+// stereo makes a second of interleaved stereo from f, which gets the time in
+// seconds.
+func stereo(f func(t float64) float64) []float32 {
+	out := make([]float32, 2*testRate)
+	for i := 0; i < testRate; i++ {
+		v := float32(f(float64(i) / testRate))
+		out[2*i], out[2*i+1] = v, v
+	}
+	return out
+}
+
+func sine(hz float64) []float32 {
+	return stereo(func(t float64) float64 { return 0.5 * math.Sin(2*math.Pi*hz*t) })
+}
+
+func testSignals() map[string][]float32 {
+	rng := rand.New(rand.NewSource(1))
+	return map[string][]float32{
+		"silence": make([]float32, 2*testRate),
+		"low":     sine(220),
+		"high":    sine(4000),
+		"noise":   stereo(func(float64) float64 { return rng.Float64() - 0.5 }),
+		"swell": stereo(func(t float64) float64 {
+			return math.Min(1, t/0.8) * 0.5 * math.Sin(2*math.Pi*440*t)
+		}),
+	}
+}
+
+func TestDescriptorRanges(t *testing.T) {
+	signals := testSignals()
+	values := make(map[string]map[string]float64)
+	for name, d := range Descriptors {
+		values[name] = make(map[string]float64)
+		for s, frames := range signals {
+			v := d(frames, testRate)
+			if v < 0 || v > 1 || math.IsNaN(v) {
+				t.Errorf("%s of %s is %v, outside [0,1]", name, s, v)
+			}
+			values[name][s] = v
+		}
+	}
+	if c := values["centroid"]; c["high"] <= c["low"] {
+		t.Error("4kHz sine has centroid", c["high"], "not above the 220Hz one's", c["low"])
+	}
+	if a := values["attack"]; a["swell"] <= a["low"] {
+		t.Error("swell has attack", a["swell"], "not above the sine's", a["low"])
+	}
+	if n := values["noise"]; n["noise"] <= n["low"] {
+		t.Error("white noise has noise", n["noise"], "not above the sine's", n["low"])
+	}
+}
+
+func TestObjectiveRanges(t *testing.T) {
+	signals := testSignals()
+	for name, o := range Objectives {
+		for r, ref := range signals {
+			for d, dev := range signals {
+				v := o(ref, dev, testRate)
+				if math.IsNaN(v) || v > 1+1e-9 || (name != "spectral" && v < 0) {
+					t.Errorf("%s of %s against %s is %v, out of range", name, d, r, v)
+				}
+			}
+		}
+		same := o(signals["low"], signals["low"], testRate)
+		other := o(signals["low"], signals["noise"], testRate)
+		if same < other {
+			t.Errorf("%s scores a sine against itself %v, below %v against noise", name, same, other)
+		}
+	}
+	if p := Objectives["pitch"](sine(220), sine(440), testRate); p > 0.1 {
+		t.Error("pitch of sines an octave apart is", p, "want about 0")
+	}
+}
@@ -0,0 +1,62 @@
+package audio
+
+import (
+	"math"
+)
+
+// Descriptor characterizes a stereo recording by a single number in [0,1],
+// so recordings can be binned by what they sound like rather than by how
+// close they are to the source.
+type Descriptor func(frames []float32, sample_rate int) float64
+
+// Descriptors are the characteristics available for MAP-Elites, by name.
+var Descriptors = map[string]Descriptor{
+	"centroid": centroid,
+	"attack":   attack,
+	"noise":    func(frames []float32, _ int) float64 { return flatness(frames) },
+}
+
+// maxAttack is the attack time, in seconds, that maps to 1.
+const maxAttack = 2.0
+
+// centroid is the power-weighted mean spectral centroid on a log frequency
+// scale, 0 at 20Hz and 1 at Nyquist.
+func centroid(frames []float32, sample_rate int) float64 {
+	mono, max := sum_channels_and_normalize(frames)
+	if max == 0 {
+		return 0
+	}
+	binHz := float64(sample_rate) / fftLen
+	var weighted, total float64
+	for _, power := range power_spectra(mono) {
+		var moment, sum float64
+		for i, p := range power {
+			moment += float64(i) * binHz * p
+			sum += p
+		}
+		if sum == 0 {
+			continue
+		}
+		weighted += moment
+		total += sum
+	}
+	if total == 0 {
+		return 0
+	}
+	c := weighted / total
+	if c <= 20 {
+		return 0
+	}
+	return math.Min(1, math.Log(c/20)/math.Log(float64(sample_rate)/2/20))
+}
+
+// attack is the time the RMS envelope takes to first reach 90% of its peak,
+// with maxAttack or longer mapping to 1.
+func attack(frames []float32, sample_rate int) float64 {
+	for i, rms := range rms_envelope(frames) {
+		if rms >= 0.9 {
+			return math.Min(1, float64(i*envelopeHop)/float64(sample_rate)/maxAttack)
+		}
+	}
+	return 0
+}
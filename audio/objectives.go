@@ -0,0 +1,182 @@
+package audio
+
+import (
+	"math"
+	"math/cmplx"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// Objective compares a stereo recording with stereo reference audio. Higher
+// is closer, 1 means the two can't be told apart by this criterion.
+type Objective func(ref_frames, device_frames []float32, sample_rate int) float64
+
+// Objectives are the criteria available for multi-objective scoring, by name.
+var Objectives = map[string]Objective{
+	"spectral":  spectral_envelope,
+	"amplitude": amplitude_envelope,
+	"pitch":     pitch,
+	"noise":     noisiness,
+}
+
+const envelopeHop = 512
+const fftLen = 2048
+
+func spectral_envelope(ref_frames, device_frames []float32, sample_rate int) float64 {
+	score, _ := Native_mfcc_dtw_euclidean_mono(ref_frames, device_frames, sample_rate)
+	return score
+}
+
+// amplitude_envelope compares the RMS envelopes of the two signals, each
+// normalized to its own peak so recording level doesn't matter.
+func amplitude_envelope(ref_frames, device_frames []float32, sample_rate int) float64 {
+	ref_env := rms_envelope(ref_frames)
+	device_env := rms_envelope(device_frames)
+	n := len(ref_env)
+	if len(device_env) < n {
+		n = len(device_env)
+	}
+	if n == 0 {
+		return 0
+	}
+	var diff float64
+	for i := 0; i < n; i++ {
+		diff += math.Abs(ref_env[i] - device_env[i])
+	}
+	return 1 - diff/float64(n)
+}
+
+// pitch compares estimated fundamentals, losing 1 per octave of difference.
+// Two unpitched signals match, a pitched and an unpitched one don't.
+func pitch(ref_frames, device_frames []float32, sample_rate int) float64 {
+	ref_f0 := fundamental(ref_frames, sample_rate)
+	device_f0 := fundamental(device_frames, sample_rate)
+	switch {
+	case ref_f0 == 0 && device_f0 == 0:
+		return 1
+	case ref_f0 == 0 || device_f0 == 0:
+		return 0
+	}
+	return 1 - math.Min(1, math.Abs(math.Log2(device_f0/ref_f0)))
+}
+
+// noisiness compares mean spectral flatness, which is near 0 for tones and
+// near 1 for white noise.
+func noisiness(ref_frames, device_frames []float32, sample_rate int) float64 {
+	return 1 - math.Abs(flatness(ref_frames)-flatness(device_frames))
+}
+
+func rms_envelope(frames []float32) []float64 {
+	mono, max := sum_channels_and_normalize(frames)
+	if max == 0 {
+		return nil
+	}
+	env := make([]float64, 0, len(mono)/envelopeHop)
+	var peak float64
+	for start := 0; start+envelopeHop <= len(mono); start += envelopeHop {
+		var sum float64
+		for _, s := range mono[start : start+envelopeHop] {
+			sum += s * s
+		}
+		rms := math.Sqrt(sum / envelopeHop)
+		env = append(env, rms)
+		peak = math.Max(peak, rms)
+	}
+	for i := range env {
+		env[i] /= peak
+	}
+	return env
+}
+
+// fundamental estimates f0 by autocorrelation over a window a quarter of the
+// way into the signal, past most attacks. It returns 0 for unpitched audio.
+func fundamental(frames []float32, sample_rate int) float64 {
+	const window = 4096
+	mono, max := sum_channels_and_normalize(frames)
+	if max == 0 || len(mono) < window*2 {
+		return 0
+	}
+	start := len(mono) / 4
+	if start+window*2 > len(mono) {
+		start = len(mono) - window*2
+	}
+	x := mono[start : start+window*2]
+	min_lag, max_lag := sample_rate/2000, sample_rate/30
+	if max_lag > window {
+		max_lag = window
+	}
+	var energy float64
+	for _, s := range x[:window] {
+		energy += s * s
+	}
+	if energy == 0 {
+		return 0
+	}
+	corr := make([]float64, max_lag+1)
+	best := 0.0
+	for lag := min_lag; lag <= max_lag; lag++ {
+		var sum float64
+		for i := 0; i < window; i++ {
+			sum += x[i] * x[i+lag]
+		}
+		corr[lag] = sum / energy
+		best = math.Max(best, corr[lag])
+	}
+	if best < 0.3 {
+		return 0
+	}
+	// every multiple of the period correlates about as well as the period
+	// itself, so take the first peak that comes close to the best
+	for lag := min_lag + 1; lag < max_lag; lag++ {
+		if corr[lag] >= 0.9*best && corr[lag] >= corr[lag-1] && corr[lag] >= corr[lag+1] {
+			return float64(sample_rate) / float64(lag)
+		}
+	}
+	return 0
+}
+
+// flatness is the mean spectral flatness of the non-silent frames.
+func flatness(frames []float32) float64 {
+	mono, max := sum_channels_and_normalize(frames)
+	if max == 0 {
+		return 0
+	}
+	var total float64
+	var count int
+	for _, power := range power_spectra(mono) {
+		var logsum, sum float64
+		for _, p := range power {
+			logsum += math.Log(p + 1e-12)
+			sum += p + 1e-12
+		}
+		if sum < 1e-6 {
+			continue
+		}
+		total += math.Exp(logsum/float64(len(power))) / (sum / float64(len(power)))
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
+
+// power_spectra splits mono into Hann-windowed frames of fftLen and returns
+// the power spectrum of each, up to Nyquist.
+func power_spectra(mono []float64) (out [][]float64) {
+	buf := make([]float64, fftLen)
+	for start := 0; start+fftLen <= len(mono); start += fftLen {
+		for i := range buf {
+			w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(fftLen-1))
+			buf[i] = mono[start+i] * w
+		}
+		spectrum := fft.FFTReal(buf)
+		power := make([]float64, fftLen/2)
+		for i := range power {
+			a := cmplx.Abs(spectrum[i])
+			power[i] = a * a
+		}
+		out = append(out, power)
+	}
+	return
+}
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"github.com/mkb218/fevolver/cmd/common"
 	"github.com/mkb218/fevolver/midi"
+	"github.com/mkb218/fevolver/midi/device"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/rakyll/portmidi"
 )
@@ -17,6 +19,9 @@ import (
 func main() {
 	statefile := flag.String("state", "state.gob", "(optional) Location for temp audio files (must exist)")
 	mididevice := flag.Int("mididev", -1, "MIDI device")
+	island := flag.Int("island", -1, "(optional) browse this island's generations instead")
+	archive := flag.Bool("archive", false, "(optional) browse the MAP-Elites archive by cell instead")
+	midifile := flag.String("midi-file", "", "(optional) write the patches sent into this file instead of -mididev, a standard MIDI file if it ends in .mid, else .syx sysex")
 	flag.Parse()
 	f, err := os.Open(*statefile)
 	if err != nil {
@@ -28,68 +33,129 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
-	midistream, err := midi.OpenStream(portmidi.DeviceID(*mididevice))
+	gens := s.Generations
+	if *island >= 0 {
+		if *island >= len(s.Islands) {
+			log.Panicln("state file only has", len(s.Islands), "islands")
+		}
+		gens = s.Islands[*island].Generations
+	}
+	var midistream midi.Output
+	if *midifile != "" {
+		midistream, err = midi.NewFileOutput(*midifile)
+	} else {
+		midistream, err = device.OpenStream(portmidi.DeviceID(*mididevice))
+	}
 	if err != nil {
 		log.Panic(err)
 	}
+	defer midistream.Close()
+	if *archive {
+		if s.Archive == nil {
+			log.Panicln("state file has no MAP-Elites archive")
+		}
+		browse_archive(*s.Archive, midistream)
+		return
+	}
 	for {
 		var g string
-		fmt.Printf("Please type a generation number (0-%d), or q to quit\n", len(s.Generations)-1)
+		fmt.Printf("Please type a generation number (0-%d), or q to quit\n", len(gens)-1)
 		fmt.Scan(&g)
 		if g == "q" {
 			break
 		}
 		var gi int64
 		var err error
-		if gi, err = strconv.ParseInt(g, 10, 32); err != nil || int(gi) >= len(s.Generations) {
+		if gi, err = strconv.ParseInt(g, 10, 32); err != nil || int(gi) >= len(gens) {
 			fmt.Println("Invalid integer:", g, err)
 			continue
 		}
 		for {
-			for i, p := range s.Generations[int(gi)].Patches {
-				fmt.Printf("%d.) %s FSEQ part %d len %d\n", i, p.Name, p.FseqPart, len(p.FSEQ.FseqFrames))
+			for i, p := range gens[int(gi)].Patches {
+				fmt.Printf("%d.) %s [%.8s] FSEQ part %d len %d\n", i, p.Name, p.ID, p.FseqPart, len(p.FSEQ.FseqFrames))
+			}
+			if front := gens[int(gi)].Front; len(front) > 0 {
+				fmt.Println("Pareto front on", strings.Join(gens[int(gi)].Objectives, ", "))
+				on_front := make(map[string]bool)
+				for _, id := range front {
+					on_front[id] = true
+				}
+				for i, p := range gens[int(gi)].Patches {
+					if on_front[p.ID] {
+						fmt.Printf("%d.) %s %.4f\n", i, p.Name, p.Scores)
+					}
+				}
 			}
-			fmt.Printf("Please type an individual number (0-%d), or q to quit\n", len(s.Generations[int(gi)].Patches)-1)
+			fmt.Printf("Please type an individual number (0-%d), or q to quit\n", len(gens[int(gi)].Patches)-1)
 			fmt.Scan(&g)
 			if g == "q" {
 				break
 			}
 			var ii int64
-			if ii, err = strconv.ParseInt(g, 10, 32); err != nil || int(ii) >= len(s.Generations[gi].Patches) {
+			if ii, err = strconv.ParseInt(g, 10, 32); err != nil || int(ii) >= len(gens[gi].Patches) {
 				fmt.Println("Invalid integer:", g, err)
 				continue
 			}
-			if err = midistream.SendPatch(s.Generations[gi].Patches[ii].Patch); err != nil {
+			if err = midistream.SendPatch(gens[gi].Patches[ii].Patch); err != nil {
 				fmt.Println("Error sending patch!", err)
 				return
 			}
 
-			jfile, err := os.Create(fmt.Sprintf("g%dp%d.json", gi, ii))
-			if err != nil {
-				fmt.Println("Couldn't open output file!", err)
-				continue
-			}
-			func() {
-				defer func() {
-					err := jfile.Close()
-					if err != nil {
-						fmt.Println("Error when closing json file!", err)
-					}
-				}()
-				b, err := json.MarshalIndent(s.Generations[gi].Patches[ii], "", "\t")
-				if err != nil {
-					fmt.Println("Error marshalling JSON!", err)
-					return
-				}
-				_, err = jfile.Write(b)
-				if err != nil {
-					fmt.Println("Error writing JSON!", err)
-					return
-				}
-			}()
+			write_json(fmt.Sprintf("g%dp%d.json", gi, ii), gens[gi].Patches[ii])
 
 		}
 
 	}
 
 }
+
+// browse_archive lists the occupied cells of a MAP-Elites archive and sends
+// the elite of whichever cell is typed in.
+func browse_archive(a common.Archive, midistream midi.Output) {
+	for {
+		fmt.Println("Cells over", strings.Join(a.Descriptors, ", "), "with", a.Bins, "bins each:")
+		for _, e := range a.Elites() {
+			fmt.Printf("%s.) %s [%.8s] score %.4f descriptors %.3f from gen %d\n", common.CellKey(e.Cell), e.Name, e.ID, e.Score, e.Descriptors, e.Generation)
+		}
+		fmt.Println("Please type a cell, e.g. 3,7, or q to quit")
+		var c string
+		fmt.Scan(&c)
+		if c == "q" {
+			return
+		}
+		e, ok := a.Cells[c]
+		if !ok {
+			fmt.Println("No elite in cell", c)
+			continue
+		}
+		if err := midistream.SendPatch(e.Patch); err != nil {
+			fmt.Println("Error sending patch!", err)
+			return
+		}
+		write_json(fmt.Sprintf("cell%s.json", strings.Replace(c, ",", "_", -1)), e)
+	}
+}
+
+func write_json(filename string, v interface{}) {
+	jfile, err := os.Create(filename)
+	if err != nil {
+		fmt.Println("Couldn't open output file!", err)
+		return
+	}
+	defer func() {
+		err := jfile.Close()
+		if err != nil {
+			fmt.Println("Error when closing json file!", err)
+		}
+	}()
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		fmt.Println("Error marshalling JSON!", err)
+		return
+	}
+	_, err = jfile.Write(b)
+	if err != nil {
+		fmt.Println("Error writing JSON!", err)
+		return
+	}
+}
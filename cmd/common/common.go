@@ -1,40 +1,24 @@
+// Package common names the state file types the commands share. They are
+// defined in package evolve, which other tools can import without cmd.
 package common
 
-import (
-	"github.com/mkb218/fevolver/midi"
-
-	"github.com/mkb218/gosndfile/sndfile"
+import "github.com/mkb218/fevolver/evolve"
+
+type (
+	State       = evolve.State
+	Format      = evolve.Format
+	Run         = evolve.Run
+	Archive     = evolve.Archive
+	Elite       = evolve.Elite
+	CMAES       = evolve.CMAES
+	Island      = evolve.Island
+	IslandStats = evolve.IslandStats
+	Evaluation  = evolve.Evaluation
+	ScoredPatch = evolve.ScoredPatch
+	Generation  = evolve.Generation
 )
 
-type State struct {
-	Generations []Generation
-	SourceAudio []float32
-	Format      sndfile.Info
-}
-
-type ScoredPatch struct {
-	midi.Patch
-	Score    float64
-	Filtered bool
-	Audio    []float32
-}
-
-type Generation struct {
-	Number  int
-	Patches []ScoredPatch
-}
-
-func (g *Generation) Len() int {
-	// log.Println("Generation", g.Number, "has", len(g.Patches))
-	return len(g.Patches)
-}
-
-func (g *Generation) Less(i, j int) bool {
-	// log.Println(g.Patches[i].Score, ">", g.Patches[j].Score)
-	return g.Patches[i].Score > g.Patches[j].Score
-}
-
-func (g *Generation) Swap(i, j int) {
-	// log.Println("swap", i, g.Patches[i].Score, j, g.Patches[j].Score)
-	g.Patches[i], g.Patches[j] = g.Patches[j], g.Patches[i]
+// CellKey formats cell indices as the Archive.Cells key, e.g. "3,7".
+func CellKey(cell []int) string {
+	return evolve.CellKey(cell)
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/evolve"
+	"github.com/mkb218/fevolver/midi"
+
+	"github.com/mkb218/gosndfile/sndfile"
+)
+
+// Operations of the ask/tell protocol
+const (
+	op_params = "params" // describe the vector and the features
+	op_eval   = "eval"   // record and score a vector or a patch
+	op_quit   = "quit"
+)
+
+// request is one line of input for -engine asktell. Exactly one of Vector,
+// over the parameters listed by op_params, and Patch is given for op_eval.
+// ID is echoed back so requests can be matched with replies.
+type request struct {
+	Op     string          `json:"op"`
+	ID     json.RawMessage `json:"id,omitempty"`
+	Vector []float64       `json:"vector,omitempty"`
+	Patch  *midi.Patch     `json:"patch,omitempty"`
+}
+
+// reply is one line of output for -engine asktell.
+type reply struct {
+	ID    json.RawMessage `json:"id,omitempty"`
+	Error string          `json:"error,omitempty"`
+
+	// op_params
+	Params         []midi.Param `json:"params,omitempty"`
+	FeatureNames   []string     `json:"feature_names,omitempty"`
+	ObjectiveNames []string     `json:"objective_names,omitempty"`
+
+	// op_eval
+	PatchID    string    `json:"patch_id,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Score      float64   `json:"score"`
+	Filtered   bool      `json:"filtered,omitempty"`
+	Objectives []float64 `json:"objectives,omitempty"`
+	Features   []float64 `json:"features,omitempty"`
+}
+
+// run_asktell lets an external optimizer do the searching. It reads
+// requests from opts.requests, stdin, and answers each with one line of
+// JSON on opts.replies, which must be the real stdout; everything else
+// fevolver prints has been sent to stderr. Vectors cover the parameters in
+// opts.params, the rest come from the best patch in the state. Everything
+// evaluated in a session goes into one new generation of the state. It's a
+// front end to g, which scores and stores what's asked for, rather than a
+// search of its own.
+func run_asktell(state *common.State, g *ga, audio_dir string, format sndfile.Info, opts options) (sp []common.ScoredPatch, err error) {
+	subset, err := evolve.ParamSubset(opts.params)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	base := random_patch(opts)
+	if best, ok := evolve.BestScored(state); ok {
+		base = best.Patch
+	}
+	session := common.Generation{Number: evolve.NextNumber(state), Objectives: opts.objectives}
+	state.Generations = append(state.Generations, session)
+	current := &state.Generations[len(state.Generations)-1]
+
+	in := bufio.NewScanner(opts.requests)
+	in.Buffer(nil, 16<<20) // patches with FSEQ frames make long lines
+	out := json.NewEncoder(opts.replies)
+	for in.Scan() {
+		var req request
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			out.Encode(reply{Error: "bad request: " + err.Error()})
+			continue
+		}
+		rep := reply{ID: req.ID}
+		switch req.Op {
+		case op_params:
+			for _, i := range subset {
+				rep.Params = append(rep.Params, midi.Params()[i])
+			}
+			rep.FeatureNames = opts.descriptors
+			rep.ObjectiveNames = opts.objectives
+		case op_eval:
+			var p midi.Patch
+			switch {
+			case req.Patch != nil:
+				// out of range values, program numbers and receive channels
+				// are set straight before anything reaches the synth
+				p = req.Patch.FromVector(req.Patch.Vector())
+			case len(req.Vector) == len(subset):
+				p = evolve.PatchFrom(base, subset, req.Vector)
+			default:
+				rep.Error = fmt.Sprintf("eval wants a patch or a vector of %d parameters", len(subset))
+			}
+			if rep.Error != "" {
+				break
+			}
+			i := len(current.Patches)
+			gen := common.Generation{Number: current.Number, Patches: []common.ScoredPatch{{Patch: p, ID: p.Hash()}}}
+			evolve.NamePatch(&gen.Patches[0].Patch, gen.Number, i)
+			if err := g.Evaluate(gen); err != nil {
+				log.Println("error scoring:", err)
+				rep.Error = err.Error()
+				break
+			}
+			scored := gen.Patches[0]
+			frames := read_recording(state.Cache[scored.ID].AudioFile)
+			if audio_dir != "" {
+				write_audio(audio_dir, gen.Number, i, format, frames)
+			}
+			current.Patches = append(current.Patches, scored)
+			if err := g.Store.Save(*state); err != nil {
+				fmt.Println("WARNING: couldn't save state!", err)
+			}
+			rep.PatchID = scored.ID
+			rep.Name = scored.Name
+			rep.Score = scored.Score
+			rep.Filtered = scored.Filtered
+			rep.Objectives = scored.Scores
+			if len(frames) > 0 {
+				rep.Features = describe(opts.descriptors, frames, int(state.Format.Samplerate))
+			}
+		case op_quit:
+			out.Encode(rep)
+			return current.Patches, nil
+		default:
+			rep.Error = fmt.Sprintf("unknown op %q", req.Op)
+		}
+		if err := out.Encode(rep); err != nil {
+			log.Println("couldn't reply:", err)
+			return current.Patches, err
+		}
+	}
+	if err := in.Err(); err != nil && err != io.EOF {
+		log.Println("error reading requests:", err)
+		return current.Patches, err
+	}
+	return current.Patches, nil
+}
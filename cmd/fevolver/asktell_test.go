@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/evolve"
+	"github.com/mkb218/fevolver/synth"
+
+	"github.com/mkb218/gosndfile/sndfile"
+)
+
+func TestAskTell(t *testing.T) {
+	format := sndfile.Info{Samplerate: 22050, Channels: 2}
+	state := common.State{Cache: make(map[string]common.Evaluation)}
+	opts := options{params: "PerfCommon", evals: 1, selection: select_roulette}
+	g, err := new_ga(4, 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the target is a rendering of its own
+	target := synth.NewRenderer(float64(format.Samplerate))
+	target.Load(random_patch(opts))
+	ref, err := target.Play(60, 100, time.Second/4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syn := synth.NewRenderer(float64(format.Samplerate))
+	recordings := t.TempDir()
+	g.Evaluator = evolve.EvaluatorFunc(func(gen common.Generation) error {
+		return score(gen, state.Cache, opts.evals, nil, ref, format, recordings, syn, 60, 100)
+	})
+	g.Store = evolve.FileStore(filepath.Join(t.TempDir(), "state.json"))
+
+	subset, err := evolve.ParamSubset(opts.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vector := make([]float64, len(subset))
+	for i := range vector {
+		vector[i] = 0.5
+	}
+	var requests bytes.Buffer
+	enc := json.NewEncoder(&requests)
+	enc.Encode(request{Op: op_params, ID: json.RawMessage(`1`)})
+	enc.Encode(request{Op: op_eval, ID: json.RawMessage(`2`), Vector: vector})
+	enc.Encode(request{Op: op_eval, ID: json.RawMessage(`3`), Vector: vector[:1]})
+	enc.Encode(request{Op: "nonesuch", ID: json.RawMessage(`4`)})
+	enc.Encode(request{Op: op_quit, ID: json.RawMessage(`5`)})
+	requests.WriteString("never read\n")
+	var replies bytes.Buffer
+	opts.requests, opts.replies = &requests, &replies
+
+	patches, err := run_asktell(&state, g, "", format, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []reply
+	scanner := bufio.NewScanner(&replies)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var rep reply
+		if err := json.Unmarshal(scanner.Bytes(), &rep); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rep)
+	}
+	if len(got) != 5 {
+		t.Fatal("got", len(got), "replies to 5 requests")
+	}
+	for i, rep := range got {
+		if want := string(rune('1' + i)); string(rep.ID) != want {
+			t.Error("reply", i, "has id", string(rep.ID), "want", want)
+		}
+	}
+	if len(got[0].Params) != len(subset) {
+		t.Error("params listed", len(got[0].Params), "parameters, want", len(subset))
+	}
+	if got[1].Error != "" || got[1].PatchID == "" {
+		t.Fatal("eval failed:", got[1].Error)
+	}
+	if !strings.Contains(got[2].Error, "vector") {
+		t.Error("short vector wasn't refused:", got[2].Error)
+	}
+	if got[3].Error == "" {
+		t.Error("unknown op wasn't refused")
+	}
+	if len(patches) != 1 || patches[0].ID != got[1].PatchID {
+		t.Fatal("session returned", len(patches), "patches, want the one evaluated")
+	}
+	if e, ok := state.Cache[got[1].PatchID]; !ok {
+		t.Error("evaluated patch isn't in the cache")
+	} else if len(e.Scores) != 1 || e.Score() != got[1].Score {
+		t.Error("eval replied with score", got[1].Score, "but the cache has", e.Scores)
+	}
+	if l := len(state.Generations); l != 1 || len(state.Generations[l-1].Patches) != 1 {
+		t.Error("session wasn't kept as a generation of the state")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mkb218/fevolver/cmd/common"
+)
+
+// apply_config sets the flags named in filename that weren't given on the
+// command line, and returns the record of this run's settings. The file
+// is a JSON object keyed by flag name, for example
+//
+//	{"o": 2, "a": 1, "f": "target.wav", "p": 30, "engine": "cmaes", "stagnate": 20}
+//
+// Lists like -params may be given as JSON arrays. An empty filename only
+// makes the record.
+func apply_config(filename string) (run common.Run, err error) {
+	run = common.Run{Start: time.Now(), Settings: make(map[string]string)}
+	if filename != "" {
+		buf, err := os.ReadFile(filename)
+		if err != nil {
+			return run, err
+		}
+		var settings map[string]interface{}
+		d := json.NewDecoder(strings.NewReader(string(buf)))
+		d.UseNumber()
+		if err = d.Decode(&settings); err != nil {
+			return run, fmt.Errorf("%s: %v", filename, err)
+		}
+		given := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			given[f.Name] = true
+		})
+		for name, v := range settings {
+			if name == "config" || flag.Lookup(name) == nil {
+				return run, fmt.Errorf("%s: no flag -%s", filename, name)
+			}
+			if given[name] {
+				continue
+			}
+			if err = flag.Set(name, config_value(v)); err != nil {
+				return run, fmt.Errorf("%s: -%s: %v", filename, name, err)
+			}
+		}
+		run.Config = string(buf)
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		run.Settings[f.Name] = f.Value.String()
+	})
+	return run, nil
+}
+
+// config_value turns a decoded JSON value into flag syntax.
+func config_value(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		var s []string
+		for _, x := range list {
+			s = append(s, config_value(x))
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(v)
+}
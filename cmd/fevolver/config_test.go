@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyConfig(t *testing.T) {
+	popsize := flag.Int("p", 10, "population size")
+	engine := flag.String("engine", "ga", "search engine")
+	params := flag.String("params", "", "parameters")
+	filename := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(filename, []byte(`{"p": 30, "engine": "cmaes", "params": ["a", "b"]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as if -engine de was on the command line
+	if err = flag.Set("engine", "de"); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := apply_config(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *engine != "de" {
+		t.Error("config file overrode -engine from the command line, got", *engine)
+	}
+	if *popsize != 30 {
+		t.Error("-p from the config file wasn't applied, got", *popsize)
+	}
+	if *params != "a,b" {
+		t.Error("-params list came out as", *params)
+	}
+	if run.Settings["engine"] != "de" || run.Settings["p"] != "30" {
+		t.Error("run settings don't record what was used:", run.Settings)
+	}
+
+	if err = os.WriteFile(filename, []byte(`{"nonesuch": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = apply_config(filename); err == nil {
+		t.Error("unknown flag in the config file was accepted")
+	}
+}
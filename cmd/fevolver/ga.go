@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/mkb218/fevolver/evolve"
+	"github.com/mkb218/fevolver/midi"
+)
+
+// ga is the engine every population in a run is bred with, along with the
+// options of the engines built around it.
+type ga struct {
+	*evolve.Engine
+	opts options
+}
+
+func new_ga(popsize, elitism int, opts options) (*ga, error) {
+	sel, err := new_selector(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.replace == replace_nsga {
+		sel = evolve.CrowdedTournament{}
+	}
+	e := evolve.New(popsize, elitism)
+	e.Selector = sel
+	if opts.active {
+		e.Mutator = evolve.MutatorFunc(midi.MutateActive)
+	}
+	if opts.prior_mutate {
+		e.Mutator = evolve.MutatorFunc(opts.prior.Mutate)
+	}
+	e.Random = func() midi.Patch { return random_patch(opts) }
+	e.CrossoverRate = opts.crossover
+	e.Replacement = opts.replace
+	e.Lambda = opts.lambda
+	e.Objectives = opts.objectives
+	e.Species = opts.species
+	e.Radius = opts.radius
+	e.Adaptation = opts.adapt
+	e.Decay = opts.decay
+	e.Prescreen = opts.prescreen
+	return &ga{e, opts}, nil
+}
+
+// Mutation rate control for options.adapt
+const (
+	adapt_fifth = evolve.AdaptFifth
+	adapt_self  = evolve.AdaptSelf
+	adapt_decay = evolve.AdaptDecay
+)
+
+// Diversity schemes for options.species
+const (
+	species_sharing  = evolve.Sharing
+	species_crowding = evolve.Crowding
+)
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/synth"
+)
+
+// max_rating is the top of the -rate scale, which scores 1 like a perfect
+// match does.
+const max_rating = 10
+
+// rate is score for -rate: it plays each patch of gen that the cache has
+// fewer than evals ratings for and asks the user for a rating from 0 to
+// max_rating, read from in. Pressing enter alone plays it again.
+func rate(gen common.Generation, cache map[string]common.Evaluation, evals int, syn synth.Synth, midinote, velocity int8,
+	hold time.Duration, in *bufio.Reader) (err error) {
+
+	for i, p := range gen.Patches {
+		e := cache[p.ID]
+		if len(e.Scores) >= evals {
+			log.Println("gen", gen.Number, "individual", i, "already rated as", p.ID)
+		} else {
+			if err = syn.Load(p.Patch); err != nil {
+				log.Println("Error sending patch!", err)
+				return
+			}
+
+			for len(e.Scores) < evals {
+				if _, err = syn.Play(midinote, velocity, hold); err != nil {
+					return
+				}
+				fmt.Printf("gen %d individual %d: rate 0-%d, or enter to hear it again: ", gen.Number, i, max_rating)
+				line, rerr := in.ReadString('\n')
+				line = strings.TrimSpace(line)
+				if line == "" {
+					if rerr != nil {
+						return fmt.Errorf("no rating for gen %d individual %d: %v", gen.Number, i, rerr)
+					}
+					continue
+				}
+				r, perr := strconv.ParseFloat(line, 64)
+				if perr != nil || r < 0 || r > max_rating {
+					fmt.Println("ratings are numbers from 0 to", max_rating)
+					continue
+				}
+				e.Scores = append(e.Scores, r/max_rating)
+			}
+			cache[p.ID] = e
+		}
+		if e.Patch == nil {
+			patch := p.Patch
+			e.Patch = &patch
+			cache[p.ID] = e
+		}
+		gen.Patches[i].Score = e.Score()
+		gen.Patches[i].Filtered = false
+		log.Println("gen", gen.Number, "individual", i, "score", e.Score())
+	}
+	return nil
+}
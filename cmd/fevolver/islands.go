@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mkb218/fevolver/evolve"
+)
+
+// Migration topologies for options.topology
+const (
+	topology_ring   = evolve.TopologyRing
+	topology_full   = evolve.TopologyFull
+	topology_random = evolve.TopologyRandom
+)
+
+// island_rates parses a comma-separated list of mutation rates, repeating it
+// to cover n islands. An empty list gives every island the default rate.
+func island_rates(list string, def float64, n int) ([]float64, error) {
+	var rates []float64
+	if list != "" {
+		for _, f := range strings.Split(list, ",") {
+			r, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad island mutation rate %q: %v", f, err)
+			}
+			rates = append(rates, r)
+		}
+	} else {
+		rates = []float64{def}
+	}
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = rates[i%len(rates)]
+	}
+	return out, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/mkb218/fevolver/audio"
+	"github.com/mkb218/fevolver/cmd/common"
+)
+
+// descriptor_names lists audio.Descriptors for the usage message.
+func descriptor_names() []string {
+	var names []string
+	for n := range audio.Descriptors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describe computes the descriptor values of a recording.
+func describe(names []string, frames []float32, sample_rate int) []float64 {
+	out := make([]float64, len(names))
+	for i, n := range names {
+		out[i] = audio.Descriptors[n](frames, sample_rate)
+	}
+	return out
+}
+
+// describer is evolve.MAPElites.Describe for the recordings in the cache
+// of state.
+func describer(state *common.State, names []string) func(p common.ScoredPatch) ([]float64, bool) {
+	return func(p common.ScoredPatch) ([]float64, bool) {
+		frames := read_recording(state.Cache[p.ID].AudioFile)
+		if len(frames) == 0 {
+			return nil, false
+		}
+		return describe(names, frames, int(state.Format.Samplerate)), true
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/evolve"
+)
+
+// Hill climbing moves for options.climb
+const (
+	climb_coordinate = evolve.ClimbCoordinate
+	climb_stochastic = evolve.ClimbStochastic
+)
+
+// parse_refine splits a -refine argument of the form generation:individual,
+// both indices as shown by browse.
+func parse_refine(state *common.State, arg string) (common.ScoredPatch, error) {
+	parts := strings.Split(arg, ":")
+	if len(parts) != 2 {
+		return common.ScoredPatch{}, fmt.Errorf("-refine wants generation:individual, got %q", arg)
+	}
+	g, err := strconv.Atoi(parts[0])
+	if err != nil || g < 0 || g >= len(state.Generations) {
+		return common.ScoredPatch{}, fmt.Errorf("no generation %q in a state with %d", parts[0], len(state.Generations))
+	}
+	i, err := strconv.Atoi(parts[1])
+	if err != nil || i < 0 || i >= len(state.Generations[g].Patches) {
+		return common.ScoredPatch{}, fmt.Errorf("no individual %q in generation %d", parts[1], g)
+	}
+	p := state.Generations[g].Patches[i]
+	p.ID = p.Hash()
+	return p, nil
+}
+
+// run_refine hill climbs from the individual named by opts.refine over the
+// parameters in opts.params with g.Refine.
+func run_refine(state *common.State, g *ga, threshold float64, opts options) (sp []common.ScoredPatch, err error) {
+	start, err := parse_refine(state, opts.refine)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	subset, err := evolve.ParamSubset(opts.params)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	sp, err = g.Refine(state, start, evolve.Climb{Params: subset, Moves: opts.climb, Step: opts.step, Steps: opts.steps, Threshold: threshold})
+	if err != nil {
+		fmt.Println(err)
+	}
+	return
+}
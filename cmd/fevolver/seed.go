@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/evolve"
+	"github.com/mkb218/fevolver/midi"
+)
+
+// load_seeds reads the patches in files and in every .syx file under dir.
+// Files that can't be read are logged and skipped.
+func load_seeds(dir string, files []string) (seeds []midi.Patch, err error) {
+	if dir != "" {
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".syx") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range files {
+		patches, err := midi.PatchesFromSYXFile(f)
+		if err != nil {
+			log.Println("skipping seed file", f+":", err)
+			continue
+		}
+		log.Println("read", len(patches), "patches from", f)
+		seeds = append(seeds, patches...)
+	}
+	return seeds, nil
+}
+
+// seed_generation makes generation 0 from every distinct seed, then, if fill
+// is set, mutated copies of them until there are popsize patches.
+func seed_generation(seeds []midi.Patch, popsize int, fill bool, g *ga, mutation float64) common.Generation {
+	gen := common.Generation{Number: 0, Objectives: g.Objectives}
+	seen := make(map[string]bool)
+	add := func(p midi.Patch) bool {
+		sp := common.ScoredPatch{Patch: p}
+		sp.ID = sp.Hash()
+		if seen[sp.ID] {
+			return false
+		}
+		seen[sp.ID] = true
+		gen.Patches = append(gen.Patches, sp)
+		return true
+	}
+	for _, p := range seeds {
+		name := p.PerfCommon.Name
+		if add(p) {
+			log.Printf("seed G0P%d is %q", len(gen.Patches)-1, strings.TrimSpace(name))
+		}
+	}
+	// give up on filling if the mutations keep making copies
+	distinct := len(gen.Patches)
+	for tries := 0; fill && distinct > 0 && len(gen.Patches) < popsize && tries < 100*popsize; tries++ {
+		add(g.Mutator.Mutate(gen.Patches[tries%distinct].Patch, mutation))
+	}
+	for i := range gen.Patches {
+		evolve.NamePatch(&gen.Patches[i].Patch, 0, i)
+	}
+	return gen
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mkb218/fevolver/evolve"
+)
+
+// Parent selection schemes for options.selection
+const (
+	select_tournament = "tournament"
+	select_roulette   = "roulette"
+	select_rank       = "rank"
+)
+
+func new_selector(opts options) (evolve.Selector, error) {
+	switch opts.selection {
+	case select_tournament:
+		if opts.tournament < 1 {
+			return nil, fmt.Errorf("tournament size must be at least 1, got %d", opts.tournament)
+		}
+		return evolve.Tournament{Size: opts.tournament}, nil
+	case select_roulette:
+		return evolve.Roulette{}, nil
+	case select_rank:
+		return evolve.Rank{}, nil
+	}
+	return nil, fmt.Errorf("unknown selection scheme %q", opts.selection)
+}
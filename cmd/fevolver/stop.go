@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/midi"
+)
+
+// stopper decides when a run is over by the rules in options. Loops check
+// the -mg generation limit themselves, but it's reported here as well.
+type stopper struct {
+	state        *common.State
+	threshold    float64
+	max_gen      int
+	opts         options
+	start        time.Time
+	recorded     int // recordings in the cache when the run started
+	best         float64
+	have_best    bool
+	since_better int // generations without a new best
+}
+
+func new_stopper(state *common.State, threshold float64, max_gen int, opts options) *stopper {
+	return &stopper{state: state, threshold: threshold, max_gen: max_gen, opts: opts, start: time.Now(), recorded: recordings(state.Cache)}
+}
+
+// recordings counts the recordings in cache.
+func recordings(cache map[string]common.Evaluation) (n int) {
+	for _, e := range cache {
+		n += len(e.Scores)
+	}
+	return
+}
+
+// diversity is the mean distance between unfiltered patches of gen.
+func diversity(gen common.Generation) float64 {
+	var vectors [][]float64
+	for _, p := range gen.Patches {
+		if !p.Filtered {
+			vectors = append(vectors, p.Vector())
+		}
+	}
+	var sum float64
+	var pairs int
+	for i := range vectors {
+		for j := i + 1; j < len(vectors); j++ {
+			sum += midi.VectorDistance(vectors[i], vectors[j])
+			pairs++
+		}
+	}
+	if pairs == 0 {
+		return 0
+	}
+	return sum / float64(pairs)
+}
+
+// reason returns why the run should stop after the scored gen, or "" if it
+// should go on. Each generation must be passed exactly once.
+func (s *stopper) reason(gen common.Generation) string {
+	if reached(gen, s.threshold) {
+		return fmt.Sprintf("a patch scored at least the target %v", s.threshold)
+	}
+	for _, p := range gen.Patches {
+		if !p.Filtered && (!s.have_best || p.Score > s.best) {
+			s.best, s.have_best, s.since_better = p.Score, true, -1
+		}
+	}
+	s.since_better++
+	if s.opts.stagnation > 0 && s.since_better >= s.opts.stagnation {
+		return fmt.Sprintf("no improvement on %v for %d generations", s.best, s.since_better)
+	}
+	if s.opts.time_budget > 0 && time.Since(s.start) >= s.opts.time_budget {
+		return fmt.Sprintf("ran for %v of the %v budget", time.Since(s.start).Round(time.Second), s.opts.time_budget)
+	}
+	if n := recordings(s.state.Cache) - s.recorded; s.opts.eval_budget > 0 && n >= s.opts.eval_budget {
+		return fmt.Sprintf("made %d of the %d recordings budgeted", n, s.opts.eval_budget)
+	}
+	if s.opts.min_diversity > 0 {
+		if d := diversity(gen); d < s.opts.min_diversity {
+			return fmt.Sprintf("mean patch distance fell to %v", d)
+		}
+	}
+	if s.max_gen > 0 && gen.Number >= s.max_gen {
+		return fmt.Sprintf("reached generation %d", s.max_gen)
+	}
+	return ""
+}
+
+// done is reason as the loops want it, recording the reason in the state.
+func (s *stopper) done(gen common.Generation) bool {
+	r := s.reason(gen)
+	if r == "" {
+		return false
+	}
+	log.Println("stopping:", r)
+	s.state.StopReason = r
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/midi"
+)
+
+func scored_generation(number int, scores ...float64) common.Generation {
+	gen := common.Generation{Number: number}
+	for _, s := range scores {
+		p := common.ScoredPatch{Patch: midi.RandomPatch(), Score: s}
+		p.ID = p.Hash()
+		gen.Patches = append(gen.Patches, p)
+	}
+	return gen
+}
+
+func TestStopReasons(t *testing.T) {
+	new_state := func() *common.State {
+		return &common.State{Cache: make(map[string]common.Evaluation)}
+	}
+	for _, c := range []struct {
+		name      string
+		want      string
+		threshold float64
+		max_gen   int
+		opts      options
+		setup     func(s *stopper)
+		gens      []common.Generation
+	}{
+		{name: "threshold", want: "target", threshold: 0.9,
+			gens: []common.Generation{scored_generation(1, 0.5, 0.95)}},
+		{name: "stagnation", want: "no improvement", threshold: 2, opts: options{stagnation: 2},
+			gens: []common.Generation{scored_generation(1, 0.5), scored_generation(2, 0.4), scored_generation(3, 0.5)}},
+		{name: "time budget", want: "budget", threshold: 2, opts: options{time_budget: time.Minute},
+			setup: func(s *stopper) { s.start = time.Now().Add(-time.Hour) },
+			gens:  []common.Generation{scored_generation(1, 0.5)}},
+		{name: "eval budget", want: "recordings budgeted", threshold: 2, opts: options{eval_budget: 3},
+			setup: func(s *stopper) {
+				s.state.Cache["a"] = common.Evaluation{Scores: []float64{0.1, 0.2}}
+				s.state.Cache["b"] = common.Evaluation{Scores: []float64{0.3}}
+			},
+			gens: []common.Generation{scored_generation(1, 0.5)}},
+		{name: "min diversity", want: "distance", threshold: 2, opts: options{min_diversity: 0.01},
+			gens: func() []common.Generation {
+				gen := scored_generation(1, 0.5)
+				gen.Patches = append(gen.Patches, gen.Patches[0])
+				return []common.Generation{gen}
+			}()},
+		{name: "max gen", want: "generation 2", threshold: 2, max_gen: 2,
+			gens: []common.Generation{scored_generation(1, 0.5), scored_generation(2, 0.6)}},
+	} {
+		s := new_stopper(new_state(), c.threshold, c.max_gen, c.opts)
+		if c.setup != nil {
+			c.setup(s)
+		}
+		var reason string
+		for i, gen := range c.gens {
+			reason = s.reason(gen)
+			if last := i == len(c.gens)-1; !last && reason != "" {
+				t.Errorf("%s: stopped early at generation %d: %s", c.name, gen.Number, reason)
+			}
+		}
+		if !strings.Contains(reason, c.want) {
+			t.Errorf("%s: stop reason %q doesn't mention %q", c.name, reason, c.want)
+		}
+	}
+}
+
+func TestStopperDone(t *testing.T) {
+	state := &common.State{Cache: make(map[string]common.Evaluation)}
+	s := new_stopper(state, 2, 1, options{})
+	if !s.done(scored_generation(1, 0.5)) {
+		t.Fatal("didn't stop at -mg")
+	}
+	if state.StopReason == "" {
+		t.Error("stop reason wasn't recorded in the state")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+
+	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/evolve"
+	"github.com/mkb218/fevolver/midi"
+)
+
+// surrogate predicts the score of a patch from the k recorded patches
+// nearest to it in midi.Params space, weighted by inverse distance. It's
+// trained on the cache of the running state, which it keeps following, and
+// on the caches of older state files.
+type surrogate struct {
+	k        int
+	xs       [][]float32
+	ys       []float64
+	filtered []bool
+	worst    float64 // lowest unfiltered score, stands in for filtered ones
+	ids      map[string]bool
+	cache    map[string]common.Evaluation
+}
+
+func new_surrogate(state *common.State, files []string, k int) *surrogate {
+	s := &surrogate{k: k, worst: math.Inf(1), ids: make(map[string]bool), cache: state.Cache}
+	s.learn(state)
+	for _, f := range files {
+		old, err := load_state(f)
+		if err != nil {
+			log.Println("not training on", f, err)
+			continue
+		}
+		before := len(s.ys)
+		s.learn(&old)
+		log.Println("trained on", len(s.ys)-before, "recordings from", f)
+	}
+	return s
+}
+
+// learn adds every cache entry of state whose patch can be found.
+func (s *surrogate) learn(state *common.State) {
+	known := evolve.KnownPatches(state)
+	for id, e := range state.Cache {
+		if e.Patch != nil {
+			s.add(id, *e.Patch, e)
+		} else if p, ok := known[id]; ok {
+			s.add(id, p, e)
+		}
+	}
+}
+
+func (s *surrogate) add(id string, p midi.Patch, e common.Evaluation) {
+	if s.ids[id] || len(e.Scores) == 0 {
+		return
+	}
+	s.ids[id] = true
+	v := p.Vector()
+	x := make([]float32, len(v))
+	for i := range v {
+		x[i] = float32(v[i])
+	}
+	s.xs = append(s.xs, x)
+	s.ys = append(s.ys, e.Score())
+	s.filtered = append(s.filtered, e.Filtered)
+	if !e.Filtered {
+		s.worst = math.Min(s.worst, e.Score())
+	}
+}
+
+// refresh picks up patches recorded since the last call.
+func (s *surrogate) refresh() {
+	for id, e := range s.cache {
+		if !s.ids[id] && e.Patch != nil {
+			s.add(id, *e.Patch, e)
+		}
+	}
+}
+
+func (s *surrogate) predict(p midi.Patch) float64 {
+	v := p.Vector()
+	type neighbour struct {
+		d float64
+		i int
+	}
+	var near []neighbour
+	for i, x := range s.xs {
+		var d float64
+		for j := range x {
+			diff := float64(x[j]) - v[j]
+			d += diff * diff
+		}
+		if len(near) < s.k || d < near[len(near)-1].d {
+			near = append(near, neighbour{d, i})
+			sort.Slice(near, func(a, b int) bool { return near[a].d < near[b].d })
+			if len(near) > s.k {
+				near = near[:s.k]
+			}
+		}
+	}
+	var sum, weights float64
+	for _, n := range near {
+		y := s.ys[n.i]
+		if s.filtered[n.i] {
+			y = s.worst
+		}
+		if n.d == 0 {
+			return y
+		}
+		w := 1 / math.Sqrt(n.d)
+		sum += w * y
+		weights += w
+	}
+	if weights == 0 {
+		return 0
+	}
+	return sum / weights
+}
+
+// Screen keeps the n patches the surrogate predicts will score best. Until
+// it has seen a recording it can't rank them, so it keeps the first n, which
+// are as random as any other n of freshly bred offspring.
+func (s *surrogate) Screen(patches []common.ScoredPatch, n int) []common.ScoredPatch {
+	s.refresh()
+	if len(patches) <= n {
+		return patches
+	}
+	if len(s.ys) == 0 {
+		return patches[:n]
+	}
+	predicted := make([]float64, len(patches))
+	for i := range patches {
+		predicted[i] = s.predict(patches[i].Patch)
+	}
+	order := make([]int, len(patches))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return predicted[order[a]] > predicted[order[b]] })
+	out := make([]common.ScoredPatch, n)
+	for k := range out {
+		out[k] = patches[order[k]]
+	}
+	log.Println("prescreened", len(patches), "offspring down to", n, "predicted", predicted[order[0]], "to", predicted[order[n-1]])
+	return out
+}
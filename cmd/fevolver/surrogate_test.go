@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/midi"
+)
+
+func TestColdSurrogateScreen(t *testing.T) {
+	state := common.State{Cache: make(map[string]common.Evaluation)}
+	s := new_surrogate(&state, nil, 3)
+	patches := make([]common.ScoredPatch, 12)
+	for i := range patches {
+		patches[i].Patch = midi.RandomPatch()
+	}
+	if got := s.Screen(patches, 4); len(got) != 4 {
+		t.Error("cold surrogate kept", len(got), "of 12 patches, want 4")
+	}
+	if got := s.Screen(patches[:2], 4); len(got) != 2 {
+		t.Error("surrogate kept", len(got), "of 2 patches, want 2")
+	}
+}
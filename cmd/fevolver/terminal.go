@@ -1,25 +1,29 @@
 package main
 
 import (
-	"encoding/gob"
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/mkb218/fevolver/audio"
 	"github.com/mkb218/fevolver/cmd/common"
+	"github.com/mkb218/fevolver/evolve"
 	"github.com/mkb218/fevolver/midi"
+	"github.com/mkb218/fevolver/midi/device"
+	"github.com/mkb218/fevolver/synth"
+	"github.com/mkb218/fevolver/synth/hardware"
 
 	"github.com/gordonklaus/portaudio"
 	"github.com/mkb218/gosndfile/sndfile"
-	"github.com/rakyll/portmidi"
 )
 
 func init() {
@@ -31,7 +35,7 @@ func ListMIDI(headline string) {
 	if err != nil {
 		panic(err)
 	}
-	devs := midi.GetDevices()
+	devs := device.GetDevices()
 	var min int = math.MaxInt16
 	var max int
 	for i, dev := range devs {
@@ -120,10 +124,68 @@ func main() {
 	popsize := flag.Int("p", 20, "Population size")
 	elitism := flag.Int("e", 2, "number of top-ranked individuals to keep unchanged")
 	mutation := flag.Float64("m", 0.1, "probability of mutation")
-	threshold := flag.Float64("t", 1000, "lower bound for completion")
-	max_gen := flag.Int("mg", -1, "maximum number of generations, <0 means only consider threshold")
+	threshold := flag.Float64("t", 1, "target score to stop at, 1 being a perfect match")
+	max_gen := flag.Int("mg", -1, "maximum number of generations, <0 means only the other stopping rules apply")
 	source := flag.String("f", "", "audio file source (must be stereo)")
+	var opts options
+	flag.BoolVar(&opts.active, "active", false, "only mutate parameters that currently affect the sound")
+	flag.IntVar(&opts.evals, "evals", 1, "number of recordings averaged into a patch's score; cached patches are re-recorded until they have this many")
+	flag.StringVar(&opts.replace, "replace", replace_generational,
+		"survivor selection: generational (elites plus offspring), plus (best of parents and offspring), comma (best of offspring) or nsga (NSGA-II, needs -objectives)")
+	flag.IntVar(&opts.lambda, "lambda", 0, "offspring per generation for -replace plus or comma, 0 means the population size")
+	flag.StringVar(&opts.selection, "sel", select_tournament, "parent selection: tournament, roulette or rank")
+	flag.IntVar(&opts.tournament, "k", 2, "tournament size for -sel tournament")
+	flag.Float64Var(&opts.crossover, "cx", 0.9, "crossover rate, parents that aren't crossed over are copied")
+	flag.IntVar(&opts.islands, "islands", 1, "number of islands (sub-populations of -p each)")
+	flag.StringVar(&opts.island_rates, "island-m", "", "comma-separated mutation probabilities per island, defaults to -m for all")
+	flag.IntVar(&opts.migrate_every, "migrate-every", 5, "generations between migrations, 0 disables migration")
+	flag.IntVar(&opts.migrants, "migrants", 2, "top individuals each island sends per migration")
+	flag.StringVar(&opts.topology, "topology", topology_ring, "migration topology: ring, full or random")
+	flag.StringVar(&opts.engine, "engine", engine_ga, "search engine: ga, cmaes, mapelites, de or bo, or asktell to answer JSON requests on stdin from an external optimizer")
+	flag.StringVar(&opts.params, "params", "", "comma-separated parameter name prefixes the vector engines search, e.g. Voices[0].VoiceCommon,PerfCommon; empty means all")
+	flag.Float64Var(&opts.sigma, "sigma", 0.3, "initial CMA-ES step size, in units of each parameter's range")
+	flag.IntVar(&opts.cma_full, "cma-full", 300, "largest -params count CMA-ES keeps a full covariance matrix for, above it only the diagonal is learned")
+	flag.StringVar(&opts.de_strategy, "de-strategy", de_rand1bin, "differential evolution strategy: rand1bin or best1bin")
+	flag.Float64Var(&opts.de_f, "de-f", 0.5, "differential evolution weight F")
+	flag.Float64Var(&opts.de_cr, "de-cr", 0.9, "differential evolution crossover rate CR")
+	flag.IntVar(&opts.bo_points, "bo-points", 500, "most recordings, the best ones, -engine bo fits its surrogate to")
+	flag.Float64Var(&opts.xi, "xi", 0.01, "score margin expected improvement is measured from for -engine bo, larger explores more")
+	descriptors := flag.String("descriptors", "centroid,attack", "comma-separated audio descriptors binning the -engine mapelites archive, from "+strings.Join(descriptor_names(), ", "))
+	flag.IntVar(&opts.bins, "bins", 10, "bins per descriptor for -engine mapelites")
+	flag.StringVar(&opts.refine, "refine", "", "hill climb from generation:individual of the state file instead of evolving, over -params")
+	flag.StringVar(&opts.climb, "climb", climb_coordinate, "hill climbing for -refine: coordinate or stochastic")
+	flag.IntVar(&opts.steps, "steps", 100, "patches -refine may record")
+	flag.Float64Var(&opts.step, "step", 0.1, "-refine step size, in units of each parameter's range; the coordinate climb halves it when stuck")
+	flag.IntVar(&opts.prescreen, "prescreen", 1, "breed this many times the offspring and only record the ones a nearest-neighbour surrogate rates best, 1 disables")
+	train := flag.String("train", "", "comma-separated older state files, recorded against the same source, to train the -prescreen surrogate on too")
+	flag.StringVar(&opts.species, "species", "", "diversity control: sharing divides scores among a species for selection, crowding has offspring replace the nearest individual they beat; empty for neither")
+	flag.Float64Var(&opts.radius, "radius", 0.05, "patches within this mean parameter distance of a species' founder belong to it")
+	flag.IntVar(&opts.alps, "alps", 0, "number of age layers of -p each, 0 or 1 disables the age-layered population structure")
+	flag.IntVar(&opts.age_gap, "age-gap", 5, "generations between fresh bottom layers with -alps, and the age unit of the layer limits")
+	flag.StringVar(&opts.adapt, "adapt", "", "mutation rate control: fifth for the 1/5 success rule, self for per-individual rates, decay to shrink -m by -decay each generation; empty keeps -m")
+	flag.Float64Var(&opts.decay, "decay", 0.95, "factor the mutation rate is multiplied by each generation with -adapt decay")
+	flag.IntVar(&opts.stagnation, "stagnate", 0, "stop after this many generations without a better score, 0 never does")
+	flag.DurationVar(&opts.time_budget, "time", 0, "stop after the first generation finished this long into the run, e.g. 8h; 0 never does")
+	flag.IntVar(&opts.eval_budget, "max-evals", 0, "stop after the first generation that brings the recordings made in this run to this many, 0 never does")
+	flag.Float64Var(&opts.min_diversity, "min-diversity", 0, "stop when the mean distance between patches of a generation falls below this, 0 never does")
+	prior := flag.String("prior", "", "directory of .syx files to learn a prior from; random patches are drawn from it instead of uniformly")
+	flag.BoolVar(&opts.prior_mutate, "prior-mutate", false, "mutate by redrawing parameters from the -prior instead of uniformly")
+	seed_files := flag.String("seed-file", "", "comma-separated .syx files, edit buffer dumps or performance banks, whose patches make up the first generation")
+	flag.StringVar(&opts.seed_dir, "seed-dir", "", "directory searched for .syx files to seed the first generation with, like -seed-file")
+	flag.BoolVar(&opts.seed_fill, "seed-fill", true, "fill a seeded first generation up to -p with mutated copies of the seeds")
+	flag.BoolVar(&opts.rate, "rate", false, "interactive: play each patch and score it by your rating from the terminal, with no source audio")
+	flag.DurationVar(&opts.hold, "hold", 2*time.Second, "how long -rate holds the note")
+	objectives := flag.String("objectives", "", "comma-separated objectives to evolve with NSGA-II, from "+strings.Join(objective_names(), ", "))
+	flag.StringVar(&opts.synth, "synth", synth_hardware, "what plays the patches: hardware, the FS1r on -o recorded from -a, or software, an approximate rendering that needs neither")
+	flag.StringVar(&opts.midi_file, "midi-file", "", "write what would go to -o into this file instead, a standard MIDI file if it ends in .mid, else .syx sysex; patches are scored with -synth software meanwhile")
+	config := flag.String("config", "", "JSON file of flag settings, keyed by flag name; flags on the command line override it")
 	flag.Parse()
+	run, err := apply_config(*config)
+	if err != nil {
+		fmt.Println("couldn't apply -config:", err)
+		return
+	}
+	opts.run = run
 	defer func() {
 		err := portaudio.Terminate()
 		if err != nil {
@@ -135,246 +197,656 @@ func main() {
 		ListAudio("Audio devices:")
 		return
 	}
-	if *omidi == -1 || *audiodev == -1 || *source == "" {
-		fmt.Println("-o, -a, and -f are required")
+	switch opts.synth {
+	case synth_hardware, synth_software:
+	default:
+		fmt.Println("-synth must be one of", synth_hardware, synth_software)
+		return
+	}
+	if opts.rate {
+		if opts.synth != synth_hardware {
+			fmt.Println("-rate needs -synth", synth_hardware, "to be heard")
+			return
+		}
+		if opts.midi_file != "" {
+			fmt.Println("-rate needs the FS1r on -o to be heard, it can't be used with -midi-file")
+			return
+		}
+		if *omidi == -1 {
+			fmt.Println("-o is required")
+			return
+		}
+		if opts.engine == engine_mapelites || opts.engine == engine_asktell || *objectives != "" {
+			fmt.Println("-rate can't be used with -engine mapelites or asktell or with -objectives, they need recordings")
+			return
+		}
+	} else if opts.synth == synth_software || opts.midi_file != "" {
+		// nothing is recorded, the software renderer is scored
+		if *source == "" {
+			fmt.Println("-f is required")
+			return
+		}
+	} else if *omidi == -1 || *audiodev == -1 || *source == "" {
+		fmt.Println("-o or -midi-file, -a, and -f are required")
+		return
+	}
+	if *objectives != "" {
+		for _, o := range strings.Split(*objectives, ",") {
+			if audio.Objectives[o] == nil {
+				fmt.Println("unknown objective", o)
+				return
+			}
+			opts.objectives = append(opts.objectives, o)
+		}
+		opts.replace = replace_nsga
+	}
+	switch opts.replace {
+	case replace_generational, replace_plus, replace_comma:
+	case replace_nsga:
+		if len(opts.objectives) == 0 {
+			fmt.Println("-replace nsga needs -objectives to rank by")
+			return
+		}
+	default:
+		fmt.Println("-replace must be one of", replace_generational, replace_plus, replace_comma, replace_nsga)
+		return
+	}
+	switch opts.engine {
+	case engine_ga, engine_cmaes, engine_mapelites, engine_de, engine_bo:
+	case engine_asktell:
+		// stdout is for replies only
+		opts.requests, opts.replies = os.Stdin, os.Stdout
+		os.Stdout = os.Stderr
+	default:
+		fmt.Println("-engine must be one of", engine_ga, engine_cmaes, engine_mapelites, engine_de, engine_bo, engine_asktell)
+		return
+	}
+	switch opts.de_strategy {
+	case de_rand1bin, de_best1bin:
+	default:
+		fmt.Println("-de-strategy must be one of", de_rand1bin, de_best1bin)
+		return
+	}
+	for _, d := range strings.Split(*descriptors, ",") {
+		if audio.Descriptors[d] == nil {
+			fmt.Println("unknown descriptor", d)
+			return
+		}
+		opts.descriptors = append(opts.descriptors, d)
+	}
+	switch opts.species {
+	case "", species_sharing, species_crowding:
+	default:
+		fmt.Println("-species must be empty or one of", species_sharing, species_crowding)
+		return
+	}
+	if opts.alps > 1 && opts.age_gap < 1 {
+		fmt.Println("-age-gap must be at least 1")
+		return
+	}
+	// each of these picks what's searched with, only one can
+	if opts.alps > 1 && opts.islands > 1 {
+		fmt.Println("-alps and -islands can't be used together")
+		return
+	}
+	if opts.engine != engine_ga && (opts.alps > 1 || opts.islands > 1) {
+		fmt.Println("-alps and -islands only work with -engine", engine_ga)
+		return
+	}
+	if opts.engine != engine_ga && opts.engine != engine_asktell && len(opts.objectives) > 0 {
+		fmt.Println("-objectives only work with -engine", engine_ga, "or", engine_asktell)
+		return
+	}
+	if opts.refine != "" && (opts.engine != engine_ga || opts.alps > 1 || opts.islands > 1) {
+		fmt.Println("-refine can't be used with -engine, -alps or -islands")
+		return
+	}
+	switch opts.adapt {
+	case "", adapt_fifth, adapt_self, adapt_decay:
+	default:
+		fmt.Println("-adapt must be empty or one of", adapt_fifth, adapt_self, adapt_decay)
+		return
+	}
+	switch opts.climb {
+	case climb_coordinate, climb_stochastic:
+	default:
+		fmt.Println("-climb must be one of", climb_coordinate, climb_stochastic)
+		return
+	}
+	if *train != "" {
+		opts.train = strings.Split(*train, ",")
+	}
+	if opts.prior_mutate && (*prior == "" || opts.active) {
+		fmt.Println("-prior-mutate needs -prior and can't be used with -active")
+		return
+	}
+	if *prior != "" {
+		if opts.prior, err = midi.PriorFromDir(*prior); err != nil {
+			fmt.Println("couldn't learn a prior:", err)
+			return
+		}
+	}
+	if *seed_files != "" {
+		opts.seed_files = strings.Split(*seed_files, ",")
+	}
+	if opts.bins < 1 {
+		fmt.Println("-bins must be at least 1")
+		return
+	}
+	switch opts.topology {
+	case topology_ring, topology_full, topology_random:
+	default:
+		fmt.Println("-topology must be one of", topology_ring, topology_full, topology_random)
+		return
+	}
+	if opts.lambda <= 0 {
+		opts.lambda = *popsize
+	}
+	if opts.replace == replace_comma && opts.lambda < *popsize {
+		fmt.Println("-replace comma needs -lambda of at least the population size")
 		return
 	}
 	run_test(*audio_dir, *statefile, *popsize, *elitism, *max_gen, *omidi, *audiodev, *mutation, *threshold,
-		*source, int8(*note), int8(*velocity))
+		*source, int8(*note), int8(*velocity), opts)
+}
+
+// Survivor selection schemes for options.replace
+const (
+	replace_generational = evolve.Generational
+	replace_plus         = evolve.Plus
+	replace_comma        = evolve.Comma
+	replace_nsga         = evolve.NSGA // set by -objectives
+)
+
+// Synths for options.synth
+const (
+	synth_hardware = "hardware" // an FS1r on a MIDI output, recorded from an audio input
+	synth_software = "software" // synth.Renderer
+)
+
+// Search engines for options.engine
+const (
+	engine_ga        = "ga"        // the genetic algorithm, optionally with islands or NSGA-II
+	engine_cmaes     = "cmaes"     // CMA-ES over the vector of options.params
+	engine_mapelites = "mapelites" // an archive of the best patch per options.descriptors cell
+	engine_de        = "de"        // differential evolution over the vector of options.params
+	engine_bo        = "bo"        // Bayesian optimization of options.params with a GP trained on the cache
+	engine_asktell   = "asktell"   // an external optimizer asks for evaluations over stdin and stdout
+)
+
+// Differential evolution strategies for options.de_strategy
+const (
+	de_rand1bin = evolve.DERand1Bin
+	de_best1bin = evolve.DEBest1Bin
+)
+
+// options holds the GA settings that aren't positional arguments of run_test.
+type options struct {
+	active  bool   // mutate with midi.MutateActive instead of midi.Mutate
+	evals   int    // recordings to average per patch before trusting the cache
+	replace string // one of the replace_ constants
+	lambda  int    // offspring per generation for replace_plus and replace_comma
+
+	selection  string  // one of the select_ constants
+	tournament int     // tournament size for select_tournament
+	crossover  float64 // probability that a pair of parents is crossed over rather than copied
+
+	islands       int    // number of sub-populations, 1 disables the island model
+	island_rates  string // comma-separated mutation rates, one per island
+	migrate_every int    // generations between migrations
+	migrants      int    // individuals each island sends per migration
+	topology      string // one of the topology_ constants
+
+	objectives []string // audio.Objectives names for multi-objective runs
+
+	engine   string  // one of the engine_ constants
+	params   string  // comma-separated midi.Params name prefixes to search
+	sigma    float64 // initial CMA-ES step size
+	cma_full int     // parameter count above which CMA-ES goes separable
+
+	de_strategy string  // one of the de_ constants
+	de_f, de_cr float64 // differential weight and crossover rate
+
+	bo_points int     // training set cap for the GP surrogate
+	xi        float64 // expected improvement margin
+
+	prescreen int      // offspring bred per offspring recorded
+	train     []string // extra state files for the prescreen surrogate
+
+	requests io.Reader // stdin, for engine_asktell
+	replies  io.Writer // the real stdout, for engine_asktell
+
+	species string  // empty or one of the species_ constants
+	radius  float64 // species radius in midi.Distance units
+
+	alps    int // age layers, below 2 disables ALPS
+	age_gap int // generations between bottom layer restarts
+
+	adapt string  // empty or one of the adapt_ constants
+	decay float64 // per-generation factor for adapt_decay
+
+	stagnation    int           // generations without improvement before stopping
+	time_budget   time.Duration // wall clock for the run
+	eval_budget   int           // recordings for the run
+	min_diversity float64       // mean midi.Distance to stop below
+
+	descriptors []string // audio.Descriptors names, one archive axis each
+	bins        int      // archive bins per descriptor
+
+	refine string  // generation:individual to hill climb from, empty to evolve
+	climb  string  // one of the climb_ constants
+	steps  int     // evaluation budget for refine
+	step   float64 // initial refine step size
+
+	seed_dir   string   // directory of .syx files for generation 0
+	seed_files []string // .syx files for generation 0
+	seed_fill  bool     // fill generation 0 with mutated seeds
+
+	prior        *midi.Prior // random patches come from here if set
+	prior_mutate bool        // mutate with prior.Mutate
+
+	rate bool          // score by the user's ratings instead of recordings
+	hold time.Duration // note length for rate
+
+	run common.Run // recorded in the state
+
+	synth     string // one of the synth_ constants
+	midi_file string // written to instead of the -o device if set, with synth_software scoring
 }
 
 func run_test(audio_dir string, statefilename string, popsize, elitism, max_gen, midi_dev, audio_dev int,
-	mutation, threshold float64, source string, note, velo int8) (sp []common.ScoredPatch, err error) {
-	var state common.State
-	func() {
-		statefile, err := os.Open(statefilename)
-		if err != nil {
-			fmt.Println("couldn't open statefile!", err)
-			return
-		}
-		defer statefile.Close()
-		g := gob.NewDecoder(statefile)
-		err = g.Decode(&state)
-		if err != nil {
-			fmt.Println("couldn't read from statefile!", err)
-		}
-	}()
+	mutation, threshold float64, source string, note, velo int8, opts options) (sp []common.ScoredPatch, err error) {
+	state, lerr := load_state(statefilename)
+	if lerr != nil {
+		fmt.Println("couldn't read from statefile!", lerr)
+	}
 
 	var ref_frames []float32
 	var format sndfile.Info
-	if state.SourceAudio == nil || len(state.SourceAudio) == 0 {
+	if opts.rate {
+		if len(state.SourceAudio) > 0 {
+			err = fmt.Errorf("%s was scored against source audio, it can't take ratings", statefilename)
+			fmt.Println(err)
+			return
+		}
+		state.Rated = true
+	} else if state.Rated {
+		err = fmt.Errorf("%s holds ratings, run it with -rate", statefilename)
+		fmt.Println(err)
+		return
+	} else if state.SourceAudio == nil || len(state.SourceAudio) == 0 {
 		ref_frames, format, err = read_frames(source)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 		state.SourceAudio = ref_frames
-		state.Format = format
+		state.Format = state_format(format)
 	} else {
 		ref_frames = state.SourceAudio
-		format = state.Format
+		format = sndfile_info(state.Format)
 	}
 	log.Println("Read", len(ref_frames), "samples of source audio")
+	if state.Cache == nil {
+		state.Cache = make(map[string]common.Evaluation)
+	}
+	state.Runs = append(state.Runs, opts.run)
+	if opts.evals < 1 {
+		opts.evals = 1
+	}
 
-	var next_gen common.Generation
-	if l := len(state.Generations); l > 0 {
-		next_gen = state.Generations[l-1]
-		new_patches := make([]common.ScoredPatch, 0)
-		for _, p := range next_gen.Patches {
-			if !filter(p.Score) || p.Filtered {
-				log.Println("filter removed", p.Name)
-			} else {
-				new_patches = append(new_patches, p)
-			}
+	g, err := new_ga(popsize, elitism, opts)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	g.Mutation = mutation
+	if opts.prescreen > 1 {
+		g.Screener = new_surrogate(&state, opts.train, 5)
+	}
+	var syn synth.Synth
+	switch {
+	case opts.midi_file != "":
+		var out *midi.FileOutput
+		out, err = midi.NewFileOutput(opts.midi_file)
+		if err == nil {
+			syn = synth.Mirror(synth.NewRenderer(float64(format.Samplerate)), out)
 		}
-		next_gen.Patches = new_patches
-		sort.Sort(&next_gen)
-	} else {
-		next_gen.Number = -1
-	}
-
-GENERATION:
-	for (max_gen <= 0) || (next_gen.Number < max_gen) {
-		last_gen := next_gen
-		next_gen = common.Generation{Number: last_gen.Number + 1}
-		log.Println("running test on", next_gen.Number)
-		var i int
-		for ; i < elitism; i++ {
-			if i+1 > len(last_gen.Patches) {
-				break
-			}
-			log.Println("keeping", last_gen.Patches[i].Name, "for elitism")
-			next_gen.Patches = append(next_gen.Patches, last_gen.Patches[i])
+	case opts.synth == synth_software:
+		syn = synth.NewRenderer(float64(format.Samplerate))
+	case opts.rate:
+		syn, err = hardware.Open(midi_dev, -1, 0)
+	default:
+		syn, err = hardware.Open(midi_dev, audio_dev, float64(format.Samplerate))
+	}
+	if err != nil {
+		fmt.Println("couldn't open the synth:", err)
+		return nil, err
+	}
+	defer syn.Close()
+	recording_dir := filepath.Join(audio_dir, "recordings")
+	if audio_dir == "" {
+		// without -tmpdir recordings only last as long as the run
+		recording_dir, err = os.MkdirTemp("", "fevolver")
+		if err != nil {
+			fmt.Println("couldn't make a directory for recordings:", err)
+			return nil, err
 		}
-		dating_pool := last_gen.Patches[i:]
-		for i := 0; i < len(dating_pool)-1; i += 2 {
-			log.Println("Crossing over", dating_pool[i].Name, "and", dating_pool[i+1].Name)
-			child1, child2, err := midi.Crossover(&(dating_pool[i].Patch), &(dating_pool[i+1].Patch))
-			if err != nil {
-				log.Println("Error crossing over:", err)
-				continue
-			}
-
-			next_gen.Patches = append(next_gen.Patches, common.ScoredPatch{Patch: *child1}, common.ScoredPatch{Patch: *child2})
+		defer os.RemoveAll(recording_dir)
+	}
+	in := bufio.NewReader(os.Stdin)
+	g.Evaluator = evolve.EvaluatorFunc(func(gen common.Generation) error {
+		if opts.rate {
+			return rate(gen, state.Cache, opts.evals, syn, note, velo, opts.hold, in)
 		}
-
-		for len(next_gen.Patches) < popsize {
-			log.Println("Filling with random patch")
-			next_gen.Patches = append(next_gen.Patches, common.ScoredPatch{Patch: midi.RandomPatch()})
+		return score(gen, state.Cache, opts.evals, opts.objectives, ref_frames, format, recording_dir, syn, note, velo)
+	})
+	g.Store = evolve.FileStore(statefilename)
+	g.OnImprovement = func(p common.ScoredPatch) {
+		log.Println("new best", p.Name, "scored", p.Score)
+	}
+	g.OnGeneration = func(gen common.Generation) {
+		if audio_dir == "" {
+			return
+		}
+		if len(state.Islands) == 0 {
+			write_generation(audio_dir, gen, state.Cache, format)
+			return
 		}
+		for i, isl := range state.Islands {
+			if l := len(isl.Generations); l > 0 {
+				write_generation(filepath.Join(audio_dir, fmt.Sprintf("island%d", i)), isl.Generations[l-1], state.Cache, format)
+			}
+		}
+	}
 
-		for i := range next_gen.Patches {
-			next_gen.Patches[i].Patch = midi.Mutate(next_gen.Patches[i].Patch, mutation)
-			next_gen.Patches[i].PerfCommon.Name = fmt.Sprintf("G%dP%d", next_gen.Number, i)
-			next_gen.Patches[i].Voices[0].VoiceCommon.Name = fmt.Sprintf("G%dP%dV1", next_gen.Number, i)
-			next_gen.Patches[i].Voices[1].VoiceCommon.Name = fmt.Sprintf("G%dP%dV2", next_gen.Number, i)
-			next_gen.Patches[i].Voices[2].VoiceCommon.Name = fmt.Sprintf("G%dP%dV3", next_gen.Number, i)
-			next_gen.Patches[i].Voices[3].VoiceCommon.Name = fmt.Sprintf("G%dP%dV4", next_gen.Number, i)
-			next_gen.Patches[i].FSEQ.Name = fmt.Sprintf("G%dP%d", next_gen.Number, i)
+	state.StopReason = ""
+	stop := new_stopper(&state, threshold, max_gen, opts)
+	g.Done = func(gen common.Generation) bool {
+		if !stop.done(gen) {
+			return false
 		}
+		save_state(statefilename, state)
+		return true
+	}
 
-		err := score(next_gen, ref_frames, format, audio_dir, midi_dev, audio_dev, note, velo)
+	if opts.engine == engine_asktell {
+		return run_asktell(&state, g, audio_dir, format, opts)
+	}
+	if opts.refine != "" {
+		return run_refine(&state, g, threshold, opts)
+	}
+	if len(state.Generations) == 0 && (opts.seed_dir != "" || len(opts.seed_files) > 0) {
+		seeds, err := load_seeds(opts.seed_dir, opts.seed_files)
 		if err != nil {
-			fmt.Println("error scoring:", err)
+			fmt.Println("couldn't read seeds:", err)
 			return nil, err
 		}
-
-		state.Generations = append(state.Generations, next_gen)
-
-		func() {
-			var statefile *os.File
-			statefile, err = os.Create(statefilename)
+		if len(seeds) == 0 {
+			log.Println("no seed patches found, starting from random ones")
+		} else {
+			gen := seed_generation(seeds, popsize, opts.seed_fill, g, mutation)
+			err := g.Evaluate(gen)
 			if err != nil {
-				fmt.Println("WARNING: couldn't open state file for writing!", err)
-				return
+				fmt.Println("error scoring:", err)
+				return nil, err
 			}
-			defer statefile.Close()
-			g := gob.NewEncoder(statefile)
-			err = g.Encode(state)
-			if err != nil {
-				fmt.Println("WARNING: couldn't save state!", err)
-			}
-		}()
-
-		for _, p := range next_gen.Patches {
-			if p.Score > threshold {
-				break GENERATION
+			// ranked like the generations Replace makes, for -objectives
+			gen = g.Rank(gen)
+			sort.Sort(&gen)
+			state.Generations = append(state.Generations, gen)
+			err = save_state(statefilename, state)
+			g.OnGeneration(gen)
+			if g.Done(gen) {
+				return gen.Patches, err
 			}
 		}
+	}
 
-		next_patches := make([]common.ScoredPatch, 0, len(next_gen.Patches))
-		for _, p := range next_gen.Patches {
-			if !filter(p.Score) || p.Filtered {
-				log.Println("filter removed", p.Name)
-				continue
-			}
-			next_patches = append(next_patches, p)
+	if g.Strategy, err = new_strategy(&state, g, mutation, opts); err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	sp, err = g.Run(&state, max_gen)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return
+}
 
+// new_strategy picks what g.Run drives from opts, nil being the GA.
+func new_strategy(state *common.State, g *ga, mutation float64, opts options) (evolve.Strategy, error) {
+	if len(state.Islands) > 0 && (opts.engine != engine_ga || opts.alps > 1) {
+		return nil, fmt.Errorf("the state file holds islands, it can only be resumed with -engine %s and without -alps", engine_ga)
+	}
+	switch opts.engine {
+	case engine_cmaes, engine_de, engine_bo:
+		subset, err := evolve.ParamSubset(opts.params)
+		if err != nil {
+			return nil, err
 		}
-		next_gen.Patches = next_patches
-		sort.Sort(&next_gen)
-
+		switch opts.engine {
+		case engine_cmaes:
+			return &evolve.CMA{Params: subset, Sigma: opts.sigma, FullUpTo: opts.cma_full}, nil
+		case engine_de:
+			return &evolve.DE{Params: subset, Strategy: opts.de_strategy, F: opts.de_f, CR: opts.de_cr}, nil
+		}
+		return &evolve.BO{Params: subset, Points: opts.bo_points, Xi: opts.xi}, nil
+	case engine_mapelites:
+		return &evolve.MAPElites{Descriptors: opts.descriptors, Bins: opts.bins, Describe: describer(state, opts.descriptors)}, nil
 	}
+	if opts.alps > 1 {
+		return &evolve.ALPS{Layers: opts.alps, AgeGap: opts.age_gap}, nil
+	}
+	if n := len(state.Islands); n > 0 {
+		if opts.islands > 1 && opts.islands != n {
+			log.Println("state file has", n, "islands, ignoring -islands", opts.islands)
+		}
+		opts.islands = n
+	}
+	if opts.islands > 1 || len(state.Islands) > 0 {
+		rates, err := island_rates(opts.island_rates, mutation, opts.islands)
+		if err != nil {
+			return nil, err
+		}
+		return &evolve.Islands{Count: opts.islands, Rates: rates, MigrateEvery: opts.migrate_every, Migrants: opts.migrants, Topology: opts.topology}, nil
+	}
+	return nil, nil
+}
+
+// state_format is format as the state keeps it.
+func state_format(format sndfile.Info) common.Format {
+	return common.Format{Frames: format.Frames, Samplerate: format.Samplerate, Channels: format.Channels,
+		Format: int32(format.Format), Sections: format.Sections, Seekable: format.Seekable}
+}
 
-	return next_gen.Patches, err
+// sndfile_info is state_format undone.
+func sndfile_info(format common.Format) sndfile.Info {
+	return sndfile.Info{Frames: format.Frames, Samplerate: format.Samplerate, Channels: format.Channels,
+		Format: sndfile.Format(format.Format), Sections: format.Sections, Seekable: format.Seekable}
 }
 
-func score(gen common.Generation, ref_frames []float32, format sndfile.Info, audio_dir string, midi_dev, audio_dev int, midinote, velocity int8) (err error) {
-	midistream, err := midi.OpenStream(portmidi.DeviceID(midi_dev))
+// load_state reads a state file written by save_state.
+func load_state(statefilename string) (state common.State, err error) {
+	return evolve.FileStore(statefilename).Load()
+}
+
+func save_state(statefilename string, state common.State) (err error) {
+	err = evolve.FileStore(statefilename).Save(state)
 	if err != nil {
-		return err
-	}
-	defer midistream.Stream.Close()
-	noteon := []portmidi.Event{{Timestamp: 0, Status: 0x90, Data1: int64(midinote & 0x7f), Data2: int64(velocity & 0x7f)}}
-	noteoff := []portmidi.Event{{Timestamp: 0, Status: 0x80, Data1: int64(midinote & 0x7f), Data2: int64(velocity & 0x7f)}}
-	store_audio := audio_dir != ""
-	log.Println("audio", audio_dir, store_audio)
-
-	var ready sync.WaitGroup
-	var complete sync.WaitGroup
-	var locker = new(sync.RWMutex)
-	rectime := time.Duration(len(ref_frames)/2) * time.Second / 44100
+		fmt.Println("WARNING: couldn't save state!", err)
+	}
+	return
+}
+
+// reached reports whether any patch in gen scored at least threshold.
+func reached(gen common.Generation, threshold float64) bool {
+	for _, p := range gen.Patches {
+		if p.Score >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+func score(gen common.Generation, cache map[string]common.Evaluation, evals int, objectives []string, ref_frames []float32, format sndfile.Info,
+	recordings string, syn synth.Synth, midinote, velocity int8) (err error) {
+	if format.Channels <= 0 || format.Samplerate <= 0 {
+		return fmt.Errorf("source format has %d channels at %d Hz", format.Channels, format.Samplerate)
+	}
+	rectime := time.Duration(len(ref_frames)/int(format.Channels)) * time.Second / time.Duration(format.Samplerate)
 	// rectime := time.Duration(4.75 * float64(time.Second))
 	for i, p := range gen.Patches {
-		if err = midistream.SendPatch(p.Patch); err != nil {
-			log.Println("Error sending patch!", err)
-			return
+		e := cache[p.ID]
+		var last []float32 // the latest recording, once it's been made or read back
+		if len(objectives) > 0 && !same_objectives(e.ObjectiveNames, objectives) {
+			// scored in a run with other objectives, the vectors don't compare
+			e.Objectives, e.ObjectiveNames = nil, objectives
 		}
-
-		log.Println("sleeping for bulk download")
-		time.Sleep(10 * time.Second)
-
-		ready.Add(2)
-		complete.Add(2)
-		locker.Lock()
-		go func() {
-			ready.Done()
-			locker.RLock()
-			defer locker.RUnlock()
-			log.Println("noteon")
-			err := midistream.Stream.Write(noteon)
-			if err != nil {
-				log.Panic(err)
-			}
-			<-time.After(rectime)
-			err = midistream.Write(noteoff)
-			if err != nil {
-				log.Panicln(err)
-			}
-			log.Println("noteoff")
-			complete.Done()
-		}()
-
-		var device *audio.Iface
-		go func() {
-			var err error
-			device, err = audio.GetIface(audio_dev, float64(format.Samplerate), 512)
-			if err != nil {
-				log.Panic(err)
+		if len(e.Scores) >= evals {
+			log.Println("gen", gen.Number, "individual", i, "already recorded as", p.ID)
+		} else {
+			if err = syn.Load(p.Patch); err != nil {
+				log.Println("Error sending patch!", err)
+				return
 			}
-			ready.Done()
-			locker.RLock()
-			defer locker.RUnlock()
-			log.Println("recording")
-			err = device.RecordAudio(rectime)
-			if err != nil {
-				log.Panicln(err)
+
+			for len(e.Scores) < evals {
+				buf, err := syn.Play(midinote, velocity, rectime)
+				if err != nil {
+					return err
+				}
+				score, filtered := audio.Native_mfcc_dtw_euclidean_mono(ref_frames, buf, int(format.Samplerate))
+				e.Scores = append(e.Scores, score)
+				e.Filtered = filtered
+				e.AudioFile = keep_recording(recordings, p.ID, format, buf)
+				last = buf
+				if len(objectives) > 0 {
+					e.Objectives = append(e.Objectives, objective_vector(objectives, ref_frames, buf, int(format.Samplerate)))
+				}
 			}
-			complete.Done()
-		}()
-		ready.Wait()
-		locker.Unlock()
-		complete.Wait()
-
-		score, filtered := audio.Native_mfcc_dtw_euclidean_mono(ref_frames, device.Buffer, int(format.Samplerate))
-		gen.Patches[i].Score = score
-		gen.Patches[i].Filtered = filtered
-		if audio_dir != "" {
-			gen_path := filepath.Join(audio_dir, strconv.FormatInt(int64(gen.Number), 10))
-			os.MkdirAll(gen_path, 0755)
-			f := format
-			f.Format = sndfile.SF_FORMAT_WAV | sndfile.SF_FORMAT_FLOAT
-			outfile, err := sndfile.Open(filepath.Join(gen_path, fmt.Sprintf("%d.wav", i)), sndfile.Write, &f)
-			if err != nil {
-				log.Println("Couldn't open audio file!", err)
-				continue
+			cache[p.ID] = e
+		}
+		if len(objectives) > 0 && len(e.Objectives) == 0 {
+			// recorded before, without these objectives
+			if last == nil {
+				last = read_recording(e.AudioFile)
 			}
-			_, err = outfile.WriteItems(device.Buffer)
-			if err != nil {
-				log.Println("Couldn't write audio!", err)
+			if last == nil {
+				log.Println("no recording of", p.ID, "left, playing it again")
+				if err = syn.Load(p.Patch); err != nil {
+					log.Println("Error sending patch!", err)
+					return
+				}
+				if last, err = syn.Play(midinote, velocity, rectime); err != nil {
+					return err
+				}
+				e.AudioFile = keep_recording(recordings, p.ID, format, last)
 			}
+			e.Objectives = [][]float64{objective_vector(objectives, ref_frames, last, int(format.Samplerate))}
+			cache[p.ID] = e
+		}
+		if e.Patch == nil {
+			// keeps the cache usable as training data for -engine bo
+			patch := p.Patch
+			e.Patch = &patch
+			cache[p.ID] = e
+		}
+		gen.Patches[i].Score = e.Score()
+		gen.Patches[i].Filtered = e.Filtered
+		gen.Patches[i].Scores = nil
+		if len(objectives) > 0 {
+			gen.Patches[i].Scores = e.ObjectiveMeans()
+		}
+		if len(e.Scores) > 1 {
+			log.Println("gen", gen.Number, "individual", i, "score", e.Score(), "stddev", e.StdDev(), "over", len(e.Scores))
+		} else {
+			log.Println("gen", gen.Number, "individual", i, "score", e.Score())
 		}
-		log.Println("gen", gen.Number, "individual", i, "score", score)
 	}
 	return nil
 }
 
-func passthrough(score float64) bool {
-	return true
+func objective_vector(objectives []string, ref_frames, device_frames []float32, sample_rate int) []float64 {
+	out := make([]float64, len(objectives))
+	for i, o := range objectives {
+		out[i] = audio.Objectives[o](ref_frames, device_frames, sample_rate)
+	}
+	return out
 }
 
-// func filter_zeroes(score float64) bool {
-// 	return score > 0
-// }
+// same_objectives reports whether scores on objectives a are comparable
+// with scores on b.
+func same_objectives(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
 
-var filter = passthrough
+func objective_names() (names []string) {
+	for name := range audio.Objectives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// write_generation writes the recordings of gen's patches under audio_dir,
+// as write_audio names them.
+func write_generation(audio_dir string, gen common.Generation, cache map[string]common.Evaluation, format sndfile.Info) {
+	for i, p := range gen.Patches {
+		write_audio(audio_dir, gen.Number, i, format, read_recording(cache[p.ID].AudioFile))
+	}
+}
+
+func write_audio(audio_dir string, gen_number, i int, format sndfile.Info, buf []float32) {
+	if buf == nil {
+		return
+	}
+	gen_path := filepath.Join(audio_dir, strconv.FormatInt(int64(gen_number), 10))
+	os.MkdirAll(gen_path, 0755)
+	write_wav(filepath.Join(gen_path, fmt.Sprintf("%d.wav", i)), format, buf)
+}
+
+// keep_recording writes the latest recording of patch id into dir and
+// returns the file for Evaluation.AudioFile, or "" if it couldn't.
+func keep_recording(dir, id string, format sndfile.Info, buf []float32) string {
+	os.MkdirAll(dir, 0755)
+	filename := filepath.Join(dir, id+".wav")
+	if !write_wav(filename, format, buf) {
+		return ""
+	}
+	return filename
+}
+
+// read_recording reads back a file from keep_recording, nil if there's
+// none. Recordings from runs without -tmpdir are gone once the run ends.
+func read_recording(filename string) []float32 {
+	if filename == "" {
+		return nil
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return nil
+	}
+	frames, _, err := read_frames(filename)
+	if err != nil {
+		log.Println("Couldn't read recording!", err)
+		return nil
+	}
+	return frames
+}
+
+func write_wav(filename string, format sndfile.Info, buf []float32) bool {
+	f := format
+	f.Format = sndfile.SF_FORMAT_WAV | sndfile.SF_FORMAT_FLOAT
+	outfile, err := sndfile.Open(filename, sndfile.Write, &f)
+	if err != nil {
+		log.Println("Couldn't open audio file!", err)
+		return false
+	}
+	defer outfile.Close()
+	_, err = outfile.WriteItems(buf)
+	if err != nil {
+		log.Println("Couldn't write audio!", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,12 @@
+package main
+
+import "github.com/mkb218/fevolver/midi"
+
+// random_patch draws a patch from the -prior corpus if there is one, else
+// uniformly.
+func random_patch(opts options) midi.Patch {
+	if opts.prior != nil {
+		return opts.prior.Random()
+	}
+	return midi.RandomPatch()
+}
@@ -0,0 +1,147 @@
+package evolve
+
+import (
+	"log"
+	"sort"
+)
+
+// alpsLimit is the oldest an individual in layer may be before it has to
+// move up, following the polynomial scheme of Hornby's ALPS paper: 1, 2, 4,
+// 9, 16... times the age gap. The top layer has no limit.
+func alpsLimit(layer, layers, ageGap int) int {
+	switch {
+	case layer == layers-1:
+		return int(^uint(0) >> 1)
+	case layer == 0:
+		return ageGap
+	case layer == 1:
+		return 2 * ageGap
+	}
+	return layer * layer * ageGap
+}
+
+// splitLayers sorts the patches of gen into layers, best first in each.
+func splitLayers(gen Generation, layers int) [][]ScoredPatch {
+	out := make([][]ScoredPatch, layers)
+	for _, p := range gen.Patches {
+		l := p.Layer
+		if l >= layers {
+			l = layers - 1
+		}
+		out[l] = append(out[l], p)
+	}
+	for _, l := range out {
+		sort.SliceStable(l, func(a, b int) bool { return Fitness(l[a]) > Fitness(l[b]) })
+	}
+	return out
+}
+
+// ALPS is the age-layered population structure: Layers layers of
+// Engine.PopSize each, where a layer breeds from itself and the layer below
+// and only competes with individuals of a similar age. Every AgeGap
+// generations the bottom layer is replaced with Engine.Random patches,
+// giving them a chance to improve before they meet evolved ones. Each
+// generation in the state holds every layer. Offspring are mutated at
+// Engine.Mutation.
+type ALPS struct {
+	Layers, AgeGap int
+}
+
+func (s *ALPS) Next(state *State) int {
+	return NextNumber(state)
+}
+
+func (s *ALPS) Step(e *Engine, state *State) (Generation, error) {
+	layers, gap := s.Layers, s.AgeGap
+	var current Generation
+	if l := len(state.Generations); l > 0 {
+		current = Cull(state.Generations[l-1])
+	} else {
+		current.Number = -1
+	}
+
+	number := current.Number + 1
+	old := splitLayers(current, layers)
+	pools := make([]Generation, layers)
+	bred := make([]Generation, layers)
+	var all Generation
+	all.Number = number
+	var displaced []ScoredPatch // old bottom layer, offered to the next
+	for l := range old {
+		if l == 0 && (number%gap == 0 || len(old[0]) == 0) {
+			log.Println("gen", number, "starting a new bottom layer")
+			displaced = old[0]
+			for i := 0; i < e.PopSize; i++ {
+				p := ScoredPatch{Patch: e.Random()}
+				p.ID = p.Hash()
+				bred[l].Patches = append(bred[l].Patches, p)
+			}
+		} else if len(old[l]) > 0 {
+			pools[l] = Generation{Number: current.Number, Patches: old[l]}
+			if l > 0 {
+				pools[l].Patches = append(append([]ScoredPatch(nil), old[l]...), old[l-1]...)
+			}
+			sort.Sort(&pools[l])
+			bred[l] = e.Breed(pools[l], e.Mutation)
+		}
+		for i := range bred[l].Patches {
+			bred[l].Patches[i].Layer = l
+		}
+		all.Patches = append(all.Patches, bred[l].Patches...)
+	}
+	for i := range all.Patches {
+		NamePatch(&all.Patches[i].Patch, number, i)
+	}
+
+	if err := e.Evaluate(all); err != nil {
+		return all, scoreError(all, err)
+	}
+
+	// scores are back in all, hand them to the layers and replace
+	next := make([][]ScoredPatch, layers)
+	start := 0
+	for l := range bred {
+		bred[l].Number = number
+		bred[l].Patches = append([]ScoredPatch(nil), all.Patches[start:start+len(bred[l].Patches)]...)
+		start += len(bred[l].Patches)
+		if len(pools[l].Patches) > 0 {
+			next[l] = e.Replace(pools[l], bred[l]).Patches
+		} else {
+			next[l] = DropFiltered(bred[l].Patches)
+		}
+	}
+
+	if layers > 1 {
+		for _, p := range displaced {
+			p.Layer = 1
+			next[1] = append(next[1], p)
+		}
+	}
+	// the too old move up if they beat someone there
+	for l := 0; l < layers-1; l++ {
+		limit := alpsLimit(l, layers, gap)
+		var stay []ScoredPatch
+		for _, p := range next[l] {
+			if p.Age <= limit {
+				stay = append(stay, p)
+				continue
+			}
+			p.Layer = l + 1
+			next[l+1] = append(next[l+1], p)
+		}
+		next[l] = stay
+		sort.SliceStable(next[l+1], func(a, b int) bool { return Fitness(next[l+1][a]) > Fitness(next[l+1][b]) })
+		if len(next[l+1]) > e.PopSize {
+			next[l+1] = next[l+1][:e.PopSize]
+		}
+	}
+
+	gen := Generation{Number: number, Objectives: e.Objectives, Layers: layers}
+	for l := range next {
+		log.Println("gen", number, "layer", l, "has", len(next[l]), "individuals, ages up to", alpsLimit(l, layers, gap))
+		gen.Patches = append(gen.Patches, next[l]...)
+	}
+	sort.Sort(&gen)
+	state.Generations = append(state.Generations, gen)
+	return gen, nil
+}
@@ -0,0 +1,178 @@
+package evolve
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+// KnownPatches maps the IDs of every patch in the state's generations,
+// islands and archive to the patch, for cache entries without one.
+func KnownPatches(state *State) map[string]midi.Patch {
+	out := make(map[string]midi.Patch)
+	add := func(gens []Generation) {
+		for _, g := range gens {
+			for _, p := range g.Patches {
+				out[p.ID] = p.Patch
+			}
+		}
+	}
+	add(state.Generations)
+	for _, isl := range state.Islands {
+		add(isl.Generations)
+	}
+	if state.Archive != nil {
+		for _, e := range state.Archive.Cells {
+			out[e.ID] = e.Patch
+		}
+	}
+	return out
+}
+
+// trainingSet turns the cache into surrogate training data over subset,
+// keeping at most max points, the best ones. Filtered patches are given the
+// worst score that wasn't filtered. The best patch is returned as well.
+func trainingSet(state *State, subset []int, max int) (xs [][]float64, ys []float64, best midi.Patch, ok bool) {
+	type point struct {
+		p        midi.Patch
+		y        float64
+		filtered bool
+	}
+	known := KnownPatches(state)
+	var points []point
+	worst := math.Inf(1)
+	for id, e := range state.Cache {
+		if len(e.Scores) == 0 {
+			continue
+		}
+		var p midi.Patch
+		if e.Patch != nil {
+			p = *e.Patch
+		} else if p, ok = known[id]; !ok {
+			continue
+		}
+		points = append(points, point{p, e.Score(), e.Filtered})
+		if !e.Filtered {
+			worst = math.Min(worst, e.Score())
+		}
+	}
+	ok = false
+	if math.IsInf(worst, 1) {
+		return
+	}
+	for i := range points {
+		if points[i].filtered {
+			points[i].y = worst
+		}
+	}
+	sort.Slice(points, func(a, b int) bool { return points[a].y > points[b].y })
+	if len(points) > max {
+		points = points[:max]
+	}
+	for _, pt := range points {
+		xs = append(xs, SubsetVector(pt.p, subset))
+		ys = append(ys, pt.y)
+	}
+	return xs, ys, points[0].p, true
+}
+
+// expectedImprovement of a prediction over the best score seen so far.
+func expectedImprovement(mean, std, best, xi float64) float64 {
+	d := mean - best - xi
+	z := d / std
+	return d*0.5*math.Erfc(-z/math.Sqrt2) + std*math.Exp(-z*z/2)/math.Sqrt(2*math.Pi)
+}
+
+// propose maximizes expected improvement by random search, half uniform
+// over the unit cube and half around the best training points.
+func propose(g *gp, xs [][]float64, best, xi float64) []float64 {
+	const samples = 1000
+	var top []float64
+	topEI := math.Inf(-1)
+	for s := 0; s < samples; s++ {
+		x := make([]float64, len(xs[0]))
+		if s%2 == 0 {
+			for i := range x {
+				x[i] = rand.Float64()
+			}
+		} else {
+			seed := xs[rand.Intn(int(math.Min(10, float64(len(xs)))))]
+			step := []float64{0.02, 0.1, 0.3}[rand.Intn(3)]
+			for i := range x {
+				x[i] = math.Max(0, math.Min(1, seed[i]+rand.NormFloat64()*step))
+			}
+		}
+		mean, std := g.predict(x)
+		if ei := expectedImprovement(mean, std, best, xi); ei > topEI {
+			top, topEI = x, ei
+		}
+	}
+	return top
+}
+
+// BO proposes PopSize patches per generation by expected improvement under
+// a Gaussian process fitted to every recording in the cache, over the
+// parameters in Params. Everything else comes from the best recorded patch,
+// or Engine.Random before there is one. Proposals after the first in a
+// generation assume the ones before them score what the GP predicts.
+type BO struct {
+	Params []int
+	Points int     // most recordings the GP is fitted to, the best ones
+	Xi     float64 // exploration margin of expected improvement
+}
+
+func (s *BO) Next(state *State) int {
+	return NextNumber(state)
+}
+
+func (s *BO) Step(e *Engine, state *State) (Generation, error) {
+	xs, ys, base, ok := trainingSet(state, s.Params, s.Points)
+	var proposals [][]float64
+	if !ok || len(xs) < 2 {
+		log.Println("not enough recordings for a surrogate, sampling at random")
+		if !ok {
+			base = e.Random()
+		}
+		for len(proposals) < e.PopSize {
+			x := make([]float64, len(s.Params))
+			for i := range x {
+				x[i] = rand.Float64()
+			}
+			proposals = append(proposals, x)
+		}
+	} else {
+		g, err := fitBestGP(xs, ys)
+		if err != nil {
+			return Generation{}, err
+		}
+		log.Println("GP over", len(xs), "recordings, lengthscale", g.lengthscale, "noise", g.noise)
+		for len(proposals) < e.PopSize {
+			x := propose(g, xs, ys[0], s.Xi)
+			proposals = append(proposals, x)
+			mean, _ := g.predict(x)
+			xs, ys = append(xs, x), append(ys, mean)
+			if g, err = fitGP(xs, ys, g.lengthscale, g.noise); err != nil {
+				log.Println("couldn't refit with proposal", len(proposals), "so stopping there:", err)
+				break
+			}
+		}
+	}
+
+	gen := Generation{Number: NextNumber(state)}
+	for k, x := range proposals {
+		p := ScoredPatch{Patch: PatchFrom(base, s.Params, x)}
+		p.ID = p.Hash()
+		NamePatch(&p.Patch, gen.Number, k)
+		gen.Patches = append(gen.Patches, p)
+	}
+	if err := e.Evaluate(gen); err != nil {
+		return gen, scoreError(gen, err)
+	}
+
+	sort.Sort(&gen)
+	state.Generations = append(state.Generations, gen)
+	return gen, nil
+}
@@ -0,0 +1,320 @@
+package evolve
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+// Survivor selection schemes for Engine.Replacement
+const (
+	Generational = "generational" // elites plus offspring
+	Plus         = "plus"         // (μ+λ): best of parents and offspring
+	Comma        = "comma"        // (μ,λ): best of offspring
+	NSGA         = "nsga"         // NSGA-II over Engine.Objectives
+)
+
+// Mutation rate control for Engine.Adaptation
+const (
+	AdaptFifth = "fifth" // Rechenberg's 1/5 success rule
+	AdaptSelf  = "self"  // each individual carries its own rate, mutated along with it
+	AdaptDecay = "decay" // the rate shrinks by Engine.Decay every generation
+)
+
+// maxDupTries is how many times a duplicate individual is mutated again
+// before it's replaced with a random patch.
+const maxDupTries = 5
+
+// minRate keeps adaptive rates from reaching 0, where nothing would change.
+const minRate = 0.001
+
+func clampRate(rate float64) float64 {
+	return math.Max(minRate, math.Min(1, rate))
+}
+
+// childRate is the self-adaptive rate of a child of mom and dad: the
+// geometric mean of theirs, log-normally perturbed. Parents without a rate
+// count as having mutation.
+func (e *Engine) childRate(mom, dad *ScoredPatch, mutation float64) float64 {
+	if e.Adaptation != AdaptSelf {
+		return 0
+	}
+	m, d := mom.MutationRate, dad.MutationRate
+	if m == 0 {
+		m = mutation
+	}
+	if d == 0 {
+		d = mutation
+	}
+	return clampRate(math.Sqrt(m*d) * math.Exp(0.2*rand.NormFloat64()))
+}
+
+// InitialRate is the rate to resume a run at: where the previous
+// generation left off for the rules that change it, else base.
+func (e *Engine) InitialRate(base float64, last Generation) float64 {
+	if (e.Adaptation == AdaptFifth || e.Adaptation == AdaptDecay) && last.MutationRate > 0 {
+		return last.MutationRate
+	}
+	return base
+}
+
+// Adapt returns the rate for the generation after gen, which was bred at
+// rate by the last call to Breed and has been scored.
+func (e *Engine) Adapt(rate float64, gen Generation) float64 {
+	switch e.Adaptation {
+	case AdaptFifth:
+		var tried, improved int
+		for _, p := range gen.Patches {
+			if s, ok := e.parentScore[p.ID]; ok {
+				tried++
+				if !p.Filtered && p.Score > s {
+					improved++
+				}
+			}
+		}
+		if tried == 0 {
+			return rate
+		}
+		success := float64(improved) / float64(tried)
+		switch {
+		case success > 0.2:
+			rate /= 0.85
+		case success < 0.2:
+			rate *= 0.85
+		}
+		log.Println("gen", gen.Number, "success rate", success, "mutation now", clampRate(rate))
+	case AdaptDecay:
+		rate *= e.Decay
+	default:
+		return rate
+	}
+	return clampRate(rate)
+}
+
+// Breed makes the generation after last, which must have been culled.
+// The result is named but not yet scored.
+func (e *Engine) Breed(last Generation, mutation float64) (next Generation) {
+	next = Generation{Number: last.Number + 1, Objectives: e.Objectives}
+	log.Println("running test on", next.Number)
+	seen := make(map[string]bool)
+	datingPool := last.Patches
+	offspringCount := e.Lambda
+	if e.Replacement == Generational {
+		var i int
+		for ; i < e.Elitism; i++ {
+			if i+1 > len(last.Patches) {
+				break
+			}
+			log.Println("keeping", last.Patches[i].Name, "for elitism")
+			elite := last.Patches[i]
+			elite.Age++
+			next.Patches = append(next.Patches, elite)
+			seen[last.Patches[i].ID] = true
+		}
+		offspringCount = e.PopSize - i
+	} else if e.Replacement == Plus || e.Replacement == NSGA {
+		for _, p := range last.Patches {
+			seen[p.ID] = true
+		}
+	}
+
+	bredCount := offspringCount
+	if e.Screener != nil && e.Prescreen > 1 {
+		bredCount *= e.Prescreen
+	}
+	// selection sees shared scores, breeding the real patches
+	ranked := datingPool
+	if e.Species == Sharing {
+		ranked = shared(datingPool, e.Radius)
+	}
+	var offspring []ScoredPatch
+	var parentScores []float64 // the better parent's, for AdaptFifth
+	for len(datingPool) > 0 && len(offspring) < bredCount {
+		mom, dad := &datingPool[e.Selector.Pick(ranked)], &datingPool[e.Selector.Pick(ranked)]
+		// children are as old as their oldest parent's genes
+		age := mom.Age
+		if dad.Age > age {
+			age = dad.Age
+		}
+		age++
+		kids := []midi.Patch{mom.Patch, dad.Patch}
+		if rand.Float64() >= e.CrossoverRate {
+			log.Println("Copying", mom.Name, "and", dad.Name)
+		} else {
+			log.Println("Crossing over", mom.Name, "and", dad.Name)
+			child1, child2, err := e.Crossover.Cross(&(mom.Patch), &(dad.Patch))
+			if err != nil {
+				log.Println("Error crossing over, copying parents instead:", err)
+			} else {
+				kids = []midi.Patch{*child1, *child2}
+			}
+		}
+		for _, k := range kids {
+			offspring = append(offspring, ScoredPatch{Patch: k, Age: age, MutationRate: e.childRate(mom, dad, mutation)})
+			parentScores = append(parentScores, math.Max(mom.Score, dad.Score))
+		}
+	}
+
+	for len(offspring) < bredCount {
+		log.Println("Filling with random patch")
+		offspring = append(offspring, ScoredPatch{Patch: e.Random(), MutationRate: mutation})
+	}
+	offspring = offspring[:bredCount]
+
+	e.parentScore = make(map[string]float64)
+	for i := range offspring {
+		rate := mutation
+		if e.Adaptation == AdaptSelf {
+			rate = offspring[i].MutationRate
+		}
+		offspring[i].Patch = e.Mutator.Mutate(offspring[i].Patch, rate)
+		offspring[i].ID = offspring[i].Hash()
+		for tries := 0; seen[offspring[i].ID]; tries++ {
+			if tries < maxDupTries {
+				log.Println("offspring", i, "duplicates", offspring[i].ID, "mutating again")
+				offspring[i].Patch = e.Mutator.Mutate(offspring[i].Patch, rate)
+			} else {
+				log.Println("offspring", i, "still a duplicate, replacing with random patch")
+				offspring[i].Patch = e.Random()
+				offspring[i].Age = 0
+			}
+			offspring[i].ID = offspring[i].Hash()
+		}
+		seen[offspring[i].ID] = true
+		if i < len(parentScores) && offspring[i].Age > 0 {
+			e.parentScore[offspring[i].ID] = parentScores[i]
+		}
+	}
+	if bredCount > offspringCount {
+		offspring = e.Screener.Screen(offspring, offspringCount)
+	}
+	next.MutationRate = mutation
+	if e.Adaptation == AdaptSelf && len(offspring) > 0 {
+		next.MutationRate = 0
+		for _, p := range offspring {
+			next.MutationRate += p.MutationRate
+		}
+		next.MutationRate /= float64(len(offspring))
+	}
+	// elites are renamed along with the offspring, names don't change the ID
+	next.Patches = append(next.Patches, offspring...)
+	for i := range next.Patches {
+		NamePatch(&next.Patches[i].Patch, next.Number, i)
+	}
+	return
+}
+
+// Replace applies the survivor scheme to the scored next.
+func (e *Engine) Replace(last, next Generation) Generation {
+	switch {
+	case e.Species == Crowding:
+		next.Patches = crowd(last.Patches, DropFiltered(next.Patches), e.PopSize)
+		sort.Sort(&next)
+	case e.Replacement == Plus:
+		next.Patches = append(append([]ScoredPatch(nil), last.Patches...), next.Patches...)
+		fallthrough
+	case e.Replacement == Comma:
+		next.Patches = DropFiltered(next.Patches)
+		sort.Sort(&next)
+		if len(next.Patches) > e.PopSize {
+			next.Patches = next.Patches[:e.PopSize]
+		}
+	case e.Replacement == NSGA:
+		var pool []ScoredPatch
+		if !sameObjectives(last.Objectives, e.Objectives) {
+			log.Println("dropping generation", last.Number, "scored on", last.Objectives, "not", e.Objectives)
+		} else {
+			for _, p := range last.Patches {
+				if len(p.Scores) != len(e.Objectives) {
+					log.Println("dropping", p.Name, "scored on different objectives")
+					continue
+				}
+				pool = append(pool, p)
+			}
+		}
+		pool = DropFiltered(append(pool, next.Patches...))
+		next.Patches = nsgaSelect(pool, e.PopSize)
+		next.Front = paretoFront(next.Patches)
+		sort.Sort(&next)
+	}
+	if e.Species != "" {
+		log.Println("gen", next.Number, "has", AssignSpecies(next.Patches, e.Radius), "species")
+	}
+	return next
+}
+
+// Rank does to a generation that didn't come from Replace, like a seeded
+// first one, what Replace does under NSGA without cutting it down to
+// PopSize: filtered patches are dropped and the rest get their Rank,
+// Crowding and the Front. Under other replacements gen is returned as is.
+func (e *Engine) Rank(gen Generation) Generation {
+	if e.Replacement != NSGA || e.Species == Crowding {
+		return gen
+	}
+	pool := DropFiltered(gen.Patches)
+	gen.Patches = nsgaSelect(pool, len(pool))
+	gen.Front = paretoFront(gen.Patches)
+	sort.Sort(&gen)
+	return gen
+}
+
+// sameObjectives reports whether scores on objectives a rank against scores
+// on b.
+func sameObjectives(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// NamePatch names p and its voices and FSEQ after its place in a run.
+func NamePatch(p *midi.Patch, genNumber, i int) {
+	p.PerfCommon.Name = fmt.Sprintf("G%dP%d", genNumber, i)
+	p.Voices[0].VoiceCommon.Name = fmt.Sprintf("G%dP%dV1", genNumber, i)
+	p.Voices[1].VoiceCommon.Name = fmt.Sprintf("G%dP%dV2", genNumber, i)
+	p.Voices[2].VoiceCommon.Name = fmt.Sprintf("G%dP%dV3", genNumber, i)
+	p.Voices[3].VoiceCommon.Name = fmt.Sprintf("G%dP%dV4", genNumber, i)
+	p.FSEQ.Name = fmt.Sprintf("G%dP%d", genNumber, i)
+}
+
+// Cull drops filtered patches from gen and sorts the rest best first, ready
+// to be bred from.
+func Cull(gen Generation) Generation {
+	gen.Patches = DropFiltered(gen.Patches)
+	for i := range gen.Patches {
+		if gen.Patches[i].ID == "" {
+			// state files from before patches had IDs
+			gen.Patches[i].ID = gen.Patches[i].Hash()
+		}
+	}
+	sort.Sort(&gen)
+	return gen
+}
+
+// Filter decides which scores are worth breeding from.
+var Filter = func(score float64) bool {
+	return true
+}
+
+// DropFiltered returns the patches that pass Filter and weren't flagged
+// by the scorer.
+func DropFiltered(patches []ScoredPatch) []ScoredPatch {
+	out := make([]ScoredPatch, 0, len(patches))
+	for _, p := range patches {
+		if !Filter(p.Score) || p.Filtered {
+			log.Println("filter removed", p.Name)
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,326 @@
+package evolve
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// cmaes is a (μ/μ_w, λ)-CMA-ES over [0,1]^n, following Hansen's "The CMA
+// Evolution Strategy: A Tutorial". In separable mode only the diagonal of
+// the covariance is kept, which is what makes searching a whole patch
+// (thousands of parameters) affordable.
+type cmaes struct {
+	*CMAES
+	n, lambda, mu                       int
+	weights                             []float64
+	mueff, cc, cs, c1, cmu, damps, chin float64
+	b                                   [][]float64 // eigenvectors of C, columns
+	d                                   []float64   // square roots of the eigenvalues
+}
+
+func newCMAES(s *CMAES, lambda int) *cmaes {
+	c := &cmaes{CMAES: s, n: len(s.Mean), lambda: lambda, mu: lambda / 2}
+	if c.mu < 1 {
+		c.mu = 1
+	}
+	n := float64(c.n)
+	var sum, sumsq float64
+	for i := 0; i < c.mu; i++ {
+		w := math.Log(float64(c.mu)+0.5) - math.Log(float64(i+1))
+		c.weights = append(c.weights, w)
+		sum += w
+	}
+	for i := range c.weights {
+		c.weights[i] /= sum
+		sumsq += c.weights[i] * c.weights[i]
+	}
+	c.mueff = 1 / sumsq
+	c.cc = (4 + c.mueff/n) / (n + 4 + 2*c.mueff/n)
+	c.cs = (c.mueff + 2) / (n + c.mueff + 5)
+	c.c1 = 2 / ((n+1.3)*(n+1.3) + c.mueff)
+	c.cmu = math.Min(1-c.c1, 2*(c.mueff-2+1/c.mueff)/((n+2)*(n+2)+c.mueff))
+	if s.Separable {
+		// Ros and Hansen: the diagonal can learn (n+2)/3 times faster
+		c.c1 = math.Min(1, c.c1*(n+2)/3)
+		c.cmu = math.Min(1-c.c1, c.cmu*(n+2)/3)
+	}
+	c.damps = 1 + 2*math.Max(0, math.Sqrt((c.mueff-1)/(n+1))-1) + c.cs
+	c.chin = math.Sqrt(n) * (1 - 1/(4*n) + 1/(21*n*n))
+
+	if s.C == nil {
+		if s.Separable {
+			s.C = [][]float64{make([]float64, c.n)}
+			for i := range s.C[0] {
+				s.C[0][i] = 1
+			}
+		} else {
+			s.C = make([][]float64, c.n)
+			for i := range s.C {
+				s.C[i] = make([]float64, c.n)
+				s.C[i][i] = 1
+			}
+		}
+		s.Pc = make([]float64, c.n)
+		s.Ps = make([]float64, c.n)
+	}
+	c.decompose()
+	return c
+}
+
+// decompose refreshes b and d from C.
+func (c *cmaes) decompose() {
+	if c.Separable {
+		c.d = make([]float64, c.n)
+		for i, v := range c.C[0] {
+			c.d[i] = math.Sqrt(math.Max(v, 1e-20))
+		}
+		return
+	}
+	vals, vecs := eigenSym(c.C)
+	c.b = vecs
+	c.d = make([]float64, c.n)
+	for i, v := range vals {
+		c.d[i] = math.Sqrt(math.Max(v, 1e-20))
+	}
+}
+
+// ask samples lambda candidates, clamped to [0,1].
+func (c *cmaes) ask() [][]float64 {
+	out := make([][]float64, c.lambda)
+	for k := range out {
+		z := make([]float64, c.n)
+		for i := range z {
+			z[i] = rand.NormFloat64() * c.d[i]
+		}
+		y := c.rotate(z)
+		x := make([]float64, c.n)
+		for i := range x {
+			x[i] = math.Max(0, math.Min(1, c.Mean[i]+c.Sigma*y[i]))
+		}
+		out[k] = x
+	}
+	return out
+}
+
+// rotate multiplies by B, or does nothing in separable mode.
+func (c *cmaes) rotate(z []float64) []float64 {
+	if c.Separable {
+		return z
+	}
+	y := make([]float64, c.n)
+	for i := range y {
+		for j := range z {
+			y[i] += c.b[i][j] * z[j]
+		}
+	}
+	return y
+}
+
+// whiten multiplies by C^-1/2.
+func (c *cmaes) whiten(y []float64) []float64 {
+	out := make([]float64, c.n)
+	if c.Separable {
+		for i := range y {
+			out[i] = y[i] / c.d[i]
+		}
+		return out
+	}
+	tmp := make([]float64, c.n)
+	for j := range tmp {
+		for i := range y {
+			tmp[j] += c.b[i][j] * y[i]
+		}
+		tmp[j] /= c.d[j]
+	}
+	for i := range out {
+		for j := range tmp {
+			out[i] += c.b[i][j] * tmp[j]
+		}
+	}
+	return out
+}
+
+// tell updates the distribution from the candidates returned by ask and
+// their fitness, higher being better.
+func (c *cmaes) tell(xs [][]float64, fit []float64) {
+	order := make([]int, len(xs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return fit[order[a]] > fit[order[b]] })
+
+	old := append([]float64(nil), c.Mean...)
+	ys := make([][]float64, c.mu)
+	for k := 0; k < c.mu; k++ {
+		ys[k] = make([]float64, c.n)
+		for i := range ys[k] {
+			ys[k][i] = (xs[order[k]][i] - old[i]) / c.Sigma
+		}
+	}
+	yw := make([]float64, c.n)
+	for k, w := range c.weights {
+		for i := range yw {
+			yw[i] += w * ys[k][i]
+		}
+	}
+	for i := range c.Mean {
+		c.Mean[i] = old[i] + c.Sigma*yw[i]
+	}
+
+	c.Updates++
+	inv := c.whiten(yw)
+	norm := 0.0
+	for i := range c.Ps {
+		c.Ps[i] = (1-c.cs)*c.Ps[i] + math.Sqrt(c.cs*(2-c.cs)*c.mueff)*inv[i]
+		norm += c.Ps[i] * c.Ps[i]
+	}
+	norm = math.Sqrt(norm)
+	hsig := 0.0
+	if norm/math.Sqrt(1-math.Pow(1-c.cs, 2*float64(c.Updates)))/c.chin < 1.4+2/(float64(c.n)+1) {
+		hsig = 1
+	}
+	for i := range c.Pc {
+		c.Pc[i] = (1-c.cc)*c.Pc[i] + hsig*math.Sqrt(c.cc*(2-c.cc)*c.mueff)*yw[i]
+	}
+
+	decay := 1 - c.c1 - c.cmu
+	correction := (1 - hsig) * c.cc * (2 - c.cc)
+	if c.Separable {
+		for i := range c.C[0] {
+			var rankmu float64
+			for k, w := range c.weights {
+				rankmu += w * ys[k][i] * ys[k][i]
+			}
+			c.C[0][i] = decay*c.C[0][i] + c.c1*(c.Pc[i]*c.Pc[i]+correction*c.C[0][i]) + c.cmu*rankmu
+		}
+	} else {
+		for i := range c.C {
+			for j := 0; j <= i; j++ {
+				var rankmu float64
+				for k, w := range c.weights {
+					rankmu += w * ys[k][i] * ys[k][j]
+				}
+				v := decay*c.C[i][j] + c.c1*(c.Pc[i]*c.Pc[j]+correction*c.C[i][j]) + c.cmu*rankmu
+				c.C[i][j], c.C[j][i] = v, v
+			}
+		}
+	}
+	c.Sigma *= math.Exp((c.cs / c.damps) * (norm/c.chin - 1))
+	c.decompose()
+}
+
+// eigenSym diagonalizes the symmetric matrix a with cyclic Jacobi
+// rotations, returning the eigenvalues and the eigenvectors as columns.
+func eigenSym(a [][]float64) (vals []float64, vecs [][]float64) {
+	n := len(a)
+	m := make([][]float64, n)
+	vecs = make([][]float64, n)
+	for i := range m {
+		m[i] = append([]float64(nil), a[i]...)
+		vecs[i] = make([]float64, n)
+		vecs[i][i] = 1
+	}
+	for sweep := 0; sweep < 50; sweep++ {
+		var off float64
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				off += m[i][j] * m[i][j]
+			}
+		}
+		if off < 1e-22 {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if math.Abs(m[p][q]) < 1e-300 {
+					continue
+				}
+				theta := (m[q][q] - m[p][p]) / (2 * m[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				cos := 1 / math.Sqrt(t*t+1)
+				sin := t * cos
+				for k := 0; k < n; k++ {
+					mkp, mkq := m[k][p], m[k][q]
+					m[k][p], m[k][q] = cos*mkp-sin*mkq, sin*mkp+cos*mkq
+				}
+				for k := 0; k < n; k++ {
+					mpk, mqk := m[p][k], m[q][k]
+					m[p][k], m[q][k] = cos*mpk-sin*mqk, sin*mpk+cos*mqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := vecs[k][p], vecs[k][q]
+					vecs[k][p], vecs[k][q] = cos*vkp-sin*vkq, sin*vkp+cos*vkq
+				}
+			}
+		}
+	}
+	vals = make([]float64, n)
+	for i := range vals {
+		vals[i] = m[i][i]
+	}
+	return
+}
+
+// CMA is CMA-ES over the parameters in Params, indices into midi.Params
+// like ParamSubset returns. Each generation samples Engine.PopSize patches
+// around the mean of the distribution, which is kept in State.CMAES. A new
+// search starts from the best patch in the state, or Engine.Random, which
+// also supplies every parameter outside Params.
+type CMA struct {
+	Params   []int
+	Sigma    float64 // initial step size, in units of each parameter's range
+	FullUpTo int     // most Params a full covariance matrix is kept for, above it only the diagonal is learned
+	c        *cmaes
+}
+
+func (s *CMA) Next(state *State) int {
+	return NextNumber(state)
+}
+
+func (s *CMA) Step(e *Engine, state *State) (Generation, error) {
+	if state.CMAES == nil || !sameParams(s.Params, state.CMAES.Params) {
+		base := e.Random()
+		if best, ok := BestScored(state); ok {
+			base = best.Patch
+		}
+		state.CMAES = &CMAES{
+			Params:    subsetNames(s.Params),
+			Base:      base,
+			Mean:      SubsetVector(base, s.Params),
+			Sigma:     s.Sigma,
+			Separable: len(s.Params) > s.FullUpTo,
+		}
+		log.Println("starting CMA-ES over", len(s.Params), "parameters, separable:", state.CMAES.Separable)
+	}
+	if s.c == nil || s.c.CMAES != state.CMAES {
+		s.c = newCMAES(state.CMAES, e.PopSize)
+	}
+
+	number := NextNumber(state)
+	xs := s.c.ask()
+	gen := Generation{Number: number}
+	for k, x := range xs {
+		p := ScoredPatch{Patch: PatchFrom(s.c.Base, s.Params, x)}
+		p.ID = p.Hash()
+		NamePatch(&p.Patch, number, k)
+		gen.Patches = append(gen.Patches, p)
+	}
+	if err := e.Evaluate(gen); err != nil {
+		return gen, scoreError(gen, err)
+	}
+	fit := make([]float64, len(gen.Patches))
+	for k, p := range gen.Patches {
+		fit[k] = Fitness(p)
+	}
+	s.c.tell(xs, fit)
+	log.Println("CMA-ES gen", number, "sigma", s.c.Sigma)
+
+	sort.Sort(&gen)
+	state.Generations = append(state.Generations, gen)
+	return gen, nil
+}
@@ -0,0 +1,129 @@
+package evolve
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Differential evolution strategies for DE.Strategy
+const (
+	DERand1Bin = "rand1bin" // DE/rand/1/bin: mutant around a random member
+	DEBest1Bin = "best1bin" // DE/best/1/bin: mutant around the best member
+)
+
+// deTrial builds the trial vector for target i of pop, whose members are
+// vectors over the same parameters, best being the index of the fittest.
+func deTrial(pop [][]float64, i, best int, strategy string, f, cr float64) []float64 {
+	// three distinct members other than the target
+	r := make([]int, 0, 3)
+	for _, k := range rand.Perm(len(pop)) {
+		if k != i {
+			r = append(r, k)
+		}
+		if len(r) == 3 {
+			break
+		}
+	}
+	base, a, b := pop[r[0]], pop[r[1]], pop[r[2]]
+	if strategy == DEBest1Bin {
+		base, a, b = pop[best], pop[r[0]], pop[r[1]]
+	}
+	target := pop[i]
+	trial := make([]float64, len(target))
+	jrand := rand.Intn(len(target))
+	for j := range trial {
+		if j == jrand || rand.Float64() < cr {
+			trial[j] = math.Max(0, math.Min(1, base[j]+f*(a[j]-b[j])))
+		} else {
+			trial[j] = target[j]
+		}
+	}
+	return trial
+}
+
+// DE is differential evolution over the parameters in Params. Each member
+// of the population is the target of one trial per generation and is
+// replaced by it if the trial scores at least as well. Parameters outside
+// Params are inherited from the target. The first step, or one after
+// patches were filtered out, fills the population with Engine.Random.
+type DE struct {
+	Params   []int
+	Strategy string  // DERand1Bin or DEBest1Bin
+	F, CR    float64 // differential weight and crossover rate
+}
+
+func (s *DE) Next(state *State) int {
+	return NextNumber(state)
+}
+
+func (s *DE) Step(e *Engine, state *State) (Generation, error) {
+	if e.PopSize < 4 {
+		return Generation{}, fmt.Errorf("differential evolution needs a population of at least 4, got %d", e.PopSize)
+	}
+	var current Generation
+	if l := len(state.Generations); l > 0 {
+		current = Cull(state.Generations[l-1])
+	} else {
+		current.Number = -1
+	}
+	if len(current.Patches) < e.PopSize {
+		// first generation, or patches were filtered out
+		current.Number++
+		for len(current.Patches) < e.PopSize {
+			p := ScoredPatch{Patch: e.Random()}
+			p.ID = p.Hash()
+			current.Patches = append(current.Patches, p)
+		}
+		for i := range current.Patches {
+			NamePatch(&current.Patches[i].Patch, current.Number, i)
+		}
+		if err := e.Evaluate(current); err != nil {
+			return current, scoreError(current, err)
+		}
+		sort.Sort(&current)
+		state.Generations = append(state.Generations, current)
+		return current, nil
+	}
+	current.Patches = current.Patches[:e.PopSize]
+
+	pop := make([][]float64, len(current.Patches))
+	best := 0
+	for i, p := range current.Patches {
+		pop[i] = SubsetVector(p.Patch, s.Params)
+		if Fitness(p) > Fitness(current.Patches[best]) {
+			best = i
+		}
+	}
+
+	trials := Generation{Number: current.Number + 1}
+	for i, target := range current.Patches {
+		p := ScoredPatch{Patch: PatchFrom(target.Patch, s.Params, deTrial(pop, i, best, s.Strategy, s.F, s.CR))}
+		p.ID = p.Hash()
+		NamePatch(&p.Patch, trials.Number, i)
+		trials.Patches = append(trials.Patches, p)
+	}
+	if err := e.Evaluate(trials); err != nil {
+		return trials, scoreError(trials, err)
+	}
+
+	next := Generation{Number: trials.Number}
+	replaced := 0
+	for i, trial := range trials.Patches {
+		if Fitness(trial) >= Fitness(current.Patches[i]) {
+			next.Patches = append(next.Patches, trial)
+			replaced++
+		} else {
+			survivor := current.Patches[i]
+			NamePatch(&survivor.Patch, next.Number, i)
+			next.Patches = append(next.Patches, survivor)
+		}
+	}
+	log.Println("gen", next.Number, "trials replaced", replaced, "of", len(trials.Patches), "targets")
+
+	sort.Sort(&next)
+	state.Generations = append(state.Generations, next)
+	return next, nil
+}
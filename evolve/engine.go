@@ -0,0 +1,184 @@
+// Package evolve breeds populations of FS1r patches with a genetic
+// algorithm, or searches for them with one of the other strategies. Engine
+// runs them; selection, mutation, crossover, scoring and storage are
+// interfaces so other tools can supply their own, and hooks report progress
+// as it's made. The state types a run keeps are defined here too.
+package evolve
+
+import (
+	"math"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+// Selector picks the index of a parent out of a scored population.
+type Selector interface {
+	Pick(patches []ScoredPatch) int
+}
+
+// Mutator changes each parameter of a patch with probability rate.
+type Mutator interface {
+	Mutate(p midi.Patch, rate float64) midi.Patch
+}
+
+// MutatorFunc lets functions like midi.Mutate be used as Mutators.
+type MutatorFunc func(p midi.Patch, rate float64) midi.Patch
+
+func (f MutatorFunc) Mutate(p midi.Patch, rate float64) midi.Patch {
+	return f(p, rate)
+}
+
+// Crossover recombines two parents into two children.
+type Crossover interface {
+	Cross(mom, dad *midi.Patch) (child1, child2 *midi.Patch, err error)
+}
+
+// CrossoverFunc lets functions like midi.Crossover be used as Crossovers.
+type CrossoverFunc func(mom, dad *midi.Patch) (child1, child2 *midi.Patch, err error)
+
+func (f CrossoverFunc) Cross(mom, dad *midi.Patch) (*midi.Patch, *midi.Patch, error) {
+	return f(mom, dad)
+}
+
+// Evaluator scores a generation in place, setting Score, Filtered and, for
+// multi-objective runs, Scores on each of its patches.
+type Evaluator interface {
+	Evaluate(gen Generation) error
+}
+
+// EvaluatorFunc lets a function be used as an Evaluator.
+type EvaluatorFunc func(gen Generation) error
+
+func (f EvaluatorFunc) Evaluate(gen Generation) error {
+	return f(gen)
+}
+
+// Store keeps the state of a run between generations and runs.
+type Store interface {
+	Load() (State, error)
+	Save(state State) error
+}
+
+// Screener cuts bred offspring down to the n most promising before they're
+// evaluated, for Prescreen.
+type Screener interface {
+	Screen(offspring []ScoredPatch, n int) []ScoredPatch
+}
+
+// Engine is a genetic algorithm over patches, and the breeding and scoring
+// the other strategies share. The zero value isn't usable, start from New.
+type Engine struct {
+	PopSize, Elitism int
+	Selector         Selector
+	Mutator          Mutator
+	Crossover        Crossover
+	Evaluator        Evaluator
+	Store            Store                     // saved to after every generation if set
+	Strategy         Strategy                  // what Run drives, a GA if nil
+	Mutation         float64                   // probability that a parameter is mutated, before any Adaptation
+	Random           func() midi.Patch         // fills the first generation and stands in for duplicates
+	Done             func(gen Generation) bool // stops Run after gen if set
+
+	CrossoverRate float64  // probability that a pair of parents is crossed over rather than copied
+	Replacement   string   // one of Generational, Plus, Comma or NSGA
+	Lambda        int      // offspring per generation for Plus, Comma and NSGA
+	Objectives    []string // audio.Objectives names, for NSGA
+	Species       string   // empty, Sharing or Crowding
+	Radius        float64  // species radius in midi.Distance units
+	Adaptation    string   // empty, AdaptFifth, AdaptSelf or AdaptDecay
+	Decay         float64  // per-generation rate factor for AdaptDecay
+	Screener      Screener // prescreens offspring if set
+	Prescreen     int      // offspring bred per offspring kept by Screener
+
+	// Hooks, each called if set. OnEvaluate gets every generation as soon
+	// as it's scored, OnGeneration every generation Run has replaced and
+	// saved, and OnImprovement every patch that beats the best score seen
+	// by Evaluate so far.
+	OnEvaluate    func(gen Generation)
+	OnGeneration  func(gen Generation)
+	OnImprovement func(p ScoredPatch)
+
+	parentScore map[string]float64 // offspring ID to the better parent's score, from the last Breed
+	best        float64
+	haveBest    bool
+}
+
+// New returns an engine with popsize individuals, elitism of which are kept
+// each generation, bred by tournaments of 2 with midi.Mutate at a rate of
+// 0.1 and midi.Crossover. Its Evaluator must be set before it can run.
+func New(popsize, elitism int) *Engine {
+	return &Engine{
+		PopSize:       popsize,
+		Elitism:       elitism,
+		Selector:      Tournament{2},
+		Mutator:       MutatorFunc(midi.Mutate),
+		Crossover:     CrossoverFunc(midi.Crossover),
+		Random:        midi.RandomPatch,
+		Mutation:      0.1,
+		CrossoverRate: 0.9,
+		Replacement:   Generational,
+		Lambda:        popsize,
+		Decay:         0.95,
+	}
+}
+
+// Evaluate scores gen with the Evaluator and calls the OnEvaluate and
+// OnImprovement hooks.
+func (e *Engine) Evaluate(gen Generation) error {
+	if err := e.Evaluator.Evaluate(gen); err != nil {
+		return err
+	}
+	if e.OnEvaluate != nil {
+		e.OnEvaluate(gen)
+	}
+	best := -1
+	for i, p := range gen.Patches {
+		if !p.Filtered && (best < 0 || p.Score > gen.Patches[best].Score) {
+			best = i
+		}
+	}
+	if best >= 0 && (!e.haveBest || gen.Patches[best].Score > e.best) {
+		e.best, e.haveBest = gen.Patches[best].Score, true
+		if e.OnImprovement != nil {
+			e.OnImprovement(gen.Patches[best])
+		}
+	}
+	return nil
+}
+
+// Run steps the Strategy from where state left off, saving state after
+// every generation, until Done says to stop or generation maxGen is done.
+// maxGen <= 0 means no limit. It returns the final population.
+func (e *Engine) Run(state *State, maxGen int) (sp []ScoredPatch, err error) {
+	s := e.Strategy
+	if s == nil {
+		s = &GA{}
+	}
+	var gen Generation
+	for (maxGen <= 0) || (s.Next(state) <= maxGen) {
+		if gen, err = s.Step(e, state); err != nil {
+			return nil, err
+		}
+		if e.Store != nil {
+			if err = e.Store.Save(*state); err != nil {
+				return gen.Patches, err
+			}
+		}
+		if e.OnGeneration != nil {
+			e.OnGeneration(gen)
+		}
+		if e.Done != nil && e.Done(gen) {
+			break
+		}
+	}
+	return gen.Patches, nil
+}
+
+// Fitness is the score to rank by, with filtered patches worse than
+// anything that made a sound.
+func Fitness(p ScoredPatch) float64 {
+	if p.Filtered {
+		return math.Inf(-1)
+	}
+	return p.Score
+}
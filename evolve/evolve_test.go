@@ -0,0 +1,211 @@
+package evolve
+
+import (
+	"testing"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+type memStore struct {
+	saves int
+	state State
+}
+
+func (m *memStore) Load() (State, error) {
+	return m.state, nil
+}
+
+func (m *memStore) Save(state State) error {
+	m.saves++
+	m.state = state
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	target := midi.RandomPatch()
+	e := New(8, 1)
+	e.Evaluator = EvaluatorFunc(func(gen Generation) error {
+		for i := range gen.Patches {
+			gen.Patches[i].Score = 1 - midi.Distance(gen.Patches[i].Patch, target)
+		}
+		return nil
+	})
+	store := &memStore{}
+	e.Store = store
+	var evaluated, generations int
+	var best []float64
+	e.OnEvaluate = func(Generation) { evaluated++ }
+	e.OnGeneration = func(Generation) { generations++ }
+	e.OnImprovement = func(p ScoredPatch) { best = append(best, p.Score) }
+	e.Done = func(gen Generation) bool { return gen.Number == 2 }
+
+	var state State
+	sp, err := e.Run(&state, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Generations) != 3 || store.saves != 3 || evaluated != 3 || generations != 3 {
+		t.Error("ran", len(state.Generations), "generations, saved", store.saves, "evaluated", evaluated, "reported", generations, "want 3 of each")
+	}
+	if len(sp) != 8 {
+		t.Error("final population has", len(sp), "patches, want 8")
+	}
+	if len(best) == 0 {
+		t.Error("no improvement reported")
+	}
+	for i := 1; i < len(best); i++ {
+		if best[i] <= best[i-1] {
+			t.Error("improvement", i, "scored", best[i], "after", best[i-1])
+		}
+	}
+	top := sp[0].Score
+	for _, p := range sp {
+		if p.Score > top {
+			top = p.Score
+		}
+	}
+	if top != best[len(best)-1] {
+		t.Error("the elite didn't survive, best now", top, "after", best[len(best)-1])
+	}
+}
+
+func TestReplaceNSGAObjectives(t *testing.T) {
+	e := New(4, 0)
+	e.Replacement = NSGA
+	e.Objectives = []string{"pitch", "noise"}
+	scored := func(gen int, scores ...float64) ScoredPatch {
+		p := ScoredPatch{Patch: midi.RandomPatch(), Scores: scores}
+		p.ID = p.Hash()
+		NamePatch(&p.Patch, gen, 0)
+		return p
+	}
+	next := Generation{Number: 1, Objectives: e.Objectives, Patches: []ScoredPatch{scored(1, 0.1, 0.1)}}
+
+	// perfect scores, but on other objectives of the same count
+	last := Generation{Number: 0, Objectives: []string{"spectral", "amplitude"}, Patches: []ScoredPatch{scored(0, 1, 1)}}
+	if got := e.Replace(last, next); len(got.Patches) != 1 || got.Patches[0].ID != next.Patches[0].ID {
+		t.Error("parents scored on", last.Objectives, "survived against", e.Objectives)
+	}
+
+	last.Objectives = e.Objectives
+	if got := e.Replace(last, next); len(got.Patches) != 2 || got.Patches[0].ID != last.Patches[0].ID {
+		t.Error("parents scored on the same objectives didn't compete")
+	}
+}
+
+func TestRank(t *testing.T) {
+	e := New(2, 0)
+	e.Replacement = NSGA
+	e.Objectives = []string{"pitch", "noise"}
+	gen := Generation{Objectives: e.Objectives}
+	for _, scores := range [][]float64{{1, 0}, {0, 1}, {0.5, 0.5}, {0.1, 0.1}} {
+		p := ScoredPatch{Patch: midi.RandomPatch(), Scores: scores}
+		p.ID = p.Hash()
+		gen.Patches = append(gen.Patches, p)
+	}
+	filtered := ScoredPatch{Patch: midi.RandomPatch(), Filtered: true}
+	filtered.ID = filtered.Hash()
+	gen.Patches = append(gen.Patches, filtered)
+
+	got := e.Rank(gen)
+	if len(got.Patches) != 4 {
+		t.Fatal("ranking kept", len(got.Patches), "patches, want the 4 unfiltered ones")
+	}
+	if len(got.Front) != 3 {
+		t.Error("front is", got.Front, "want the 3 non-dominated patches")
+	}
+	for _, p := range got.Patches {
+		want := 0
+		if p.Scores[0] == 0.1 {
+			want = 1
+		}
+		if p.Rank != want {
+			t.Error("patch scored", p.Scores, "has rank", p.Rank, "want", want)
+		}
+	}
+
+	e.Replacement = Generational
+	if got := e.Rank(gen); len(got.Patches) != len(gen.Patches) || got.Front != nil {
+		t.Error("ranked a generation outside NSGA")
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	params, err := ParamSubset("PerfCommon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := midi.RandomPatch()
+	describe := func(p ScoredPatch) ([]float64, bool) {
+		v := SubsetVector(p.Patch, params)
+		return v[:2], true
+	}
+	for name, s := range map[string]Strategy{
+		"ga":        nil,
+		"cma":       &CMA{Params: params, Sigma: 0.3, FullUpTo: 300},
+		"de":        &DE{Params: params, Strategy: DEBest1Bin, F: 0.5, CR: 0.9},
+		"bo":        &BO{Params: params, Points: 50, Xi: 0.01},
+		"alps":      &ALPS{Layers: 2, AgeGap: 2},
+		"islands":   &Islands{Count: 2, MigrateEvery: 1, Migrants: 1, Topology: TopologyRing},
+		"mapelites": &MAPElites{Descriptors: []string{"a", "b"}, Bins: 4, Describe: describe},
+	} {
+		state := State{Cache: make(map[string]Evaluation)}
+		e := New(6, 1)
+		e.Strategy = s
+		e.Evaluator = EvaluatorFunc(func(gen Generation) error {
+			for i, p := range gen.Patches {
+				gen.Patches[i].Score = 1 - midi.Distance(p.Patch, target)
+				patch := p.Patch
+				state.Cache[p.ID] = Evaluation{Scores: []float64{gen.Patches[i].Score}, Patch: &patch}
+			}
+			return nil
+		})
+		store := &memStore{}
+		e.Store = store
+		var numbers []int
+		e.OnGeneration = func(gen Generation) { numbers = append(numbers, gen.Number) }
+		sp, err := e.Run(&state, 2)
+		if err != nil {
+			t.Error(name, err)
+			continue
+		}
+		if len(numbers) != 3 || numbers[0] != 0 || numbers[2] != 2 || store.saves != 3 {
+			t.Error(name, "made generations", numbers, "and saved", store.saves, "times, want 0 to 2 saved 3 times")
+		}
+		if len(sp) == 0 {
+			t.Error(name, "ended with no patches")
+		}
+	}
+}
+
+func TestRefine(t *testing.T) {
+	params, err := ParamSubset("PerfCommon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := midi.RandomPatch()
+	e := New(1, 0)
+	e.Evaluator = EvaluatorFunc(func(gen Generation) error {
+		for i := range gen.Patches {
+			gen.Patches[i].Score = 1 - midi.Distance(gen.Patches[i].Patch, target)
+		}
+		return nil
+	})
+	var state State
+	start := ScoredPatch{Patch: midi.RandomPatch()}
+	trail, err := e.Refine(&state, start, Climb{Params: params, Moves: ClimbCoordinate, Step: 0.1, Steps: 40, Threshold: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Generations) != 1 || len(trail) == 0 {
+		t.Fatal("refining added", len(state.Generations), "generations with", len(trail), "patches, want 1 with some")
+	}
+	for i := 1; i < len(trail); i++ {
+		if trail[i].Score > trail[i-1].Score {
+			t.Error("trail isn't sorted best first:", trail[i].Score, "after", trail[i-1].Score)
+		}
+	}
+	if len(trail) > 1 && trail[0].Score <= trail[len(trail)-1].Score {
+		t.Error("climbing didn't improve on", trail[len(trail)-1].Score)
+	}
+}
@@ -0,0 +1,156 @@
+package evolve
+
+import (
+	"errors"
+	"math"
+)
+
+// gp is a Gaussian process regression with an RBF kernel and unit signal
+// variance, fitted to standardized targets.
+type gp struct {
+	xs          [][]float64
+	lengthscale float64
+	noise       float64 // variance added to the diagonal
+	chol        [][]float64
+	alpha       []float64
+	ymean, ystd float64
+	loglik      float64
+}
+
+func (g *gp) kernel(a, b []float64) float64 {
+	var d float64
+	for i := range a {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return math.Exp(-d / (2 * g.lengthscale * g.lengthscale))
+}
+
+// fitGP conditions a GP with the given hyperparameters on xs and ys.
+func fitGP(xs [][]float64, ys []float64, lengthscale, noise float64) (*gp, error) {
+	g := &gp{xs: xs, lengthscale: lengthscale, noise: noise}
+	n := len(xs)
+	for _, y := range ys {
+		g.ymean += y
+	}
+	g.ymean /= float64(n)
+	for _, y := range ys {
+		g.ystd += (y - g.ymean) * (y - g.ymean)
+	}
+	g.ystd = math.Sqrt(g.ystd / float64(n))
+	if g.ystd == 0 {
+		g.ystd = 1
+	}
+	y := make([]float64, n)
+	for i := range ys {
+		y[i] = (ys[i] - g.ymean) / g.ystd
+	}
+
+	k := make([][]float64, n)
+	for i := range k {
+		k[i] = make([]float64, n)
+		for j := 0; j <= i; j++ {
+			k[i][j] = g.kernel(xs[i], xs[j])
+			k[j][i] = k[i][j]
+		}
+		k[i][i] += noise
+	}
+	var err error
+	if g.chol, err = cholesky(k); err != nil {
+		return nil, err
+	}
+	g.alpha = cholSolve(g.chol, y)
+
+	g.loglik = -0.5 * float64(n) * math.Log(2*math.Pi)
+	for i := range y {
+		g.loglik -= 0.5*y[i]*g.alpha[i] + math.Log(g.chol[i][i])
+	}
+	return g, nil
+}
+
+// fitBestGP fits over a small grid of lengthscales and noise levels and
+// keeps the fit with the highest marginal likelihood.
+func fitBestGP(xs [][]float64, ys []float64) (best *gp, err error) {
+	dim := math.Sqrt(float64(len(xs[0])))
+	for _, l := range []float64{0.05, 0.1, 0.2, 0.4, 0.8} {
+		for _, noise := range []float64{1e-3, 1e-2, 1e-1} {
+			g, err := fitGP(xs, ys, l*dim, noise)
+			if err != nil {
+				continue
+			}
+			if best == nil || g.loglik > best.loglik {
+				best = g
+			}
+		}
+	}
+	if best == nil {
+		return nil, errors.New("no GP hyperparameters gave a positive definite kernel matrix")
+	}
+	return best, nil
+}
+
+// predict returns the posterior mean and standard deviation at x, in the
+// units of the training targets.
+func (g *gp) predict(x []float64) (mean, std float64) {
+	kx := make([]float64, len(g.xs))
+	for i, xi := range g.xs {
+		kx[i] = g.kernel(x, xi)
+		mean += kx[i] * g.alpha[i]
+	}
+	v := forward(g.chol, kx)
+	variance := 1.0
+	for _, vi := range v {
+		variance -= vi * vi
+	}
+	return g.ymean + mean*g.ystd, math.Sqrt(math.Max(variance, 1e-12)) * g.ystd
+}
+
+// cholesky returns the lower triangular L with L·Lᵀ = a.
+func cholesky(a [][]float64) ([][]float64, error) {
+	n := len(a)
+	l := make([][]float64, n)
+	for i := range l {
+		l[i] = make([]float64, n)
+		for j := 0; j <= i; j++ {
+			sum := a[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if sum <= 0 {
+					return nil, errors.New("matrix is not positive definite")
+				}
+				l[i][i] = math.Sqrt(sum)
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l, nil
+}
+
+// forward solves L·x = b.
+func forward(l [][]float64, b []float64) []float64 {
+	x := make([]float64, len(b))
+	for i := range x {
+		sum := b[i]
+		for k := 0; k < i; k++ {
+			sum -= l[i][k] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
+
+// cholSolve solves L·Lᵀ·x = b.
+func cholSolve(l [][]float64, b []float64) []float64 {
+	y := forward(l, b)
+	x := make([]float64, len(y))
+	for i := len(y) - 1; i >= 0; i-- {
+		sum := y[i]
+		for k := i + 1; k < len(y); k++ {
+			sum -= l[k][i] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
@@ -0,0 +1,178 @@
+package evolve
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+)
+
+// Migration topologies for Islands.Topology
+const (
+	TopologyRing   = "ring"   // island i sends to island i+1
+	TopologyFull   = "full"   // every island sends to every other
+	TopologyRandom = "random" // each island sends to one other picked at random
+)
+
+// Islands runs the GA on Count sub-populations, kept in State.Islands, each
+// with its own mutation rate, adapted by Engine.Adaptation. Every
+// MigrateEvery generations the best Migrants of each island are copied over
+// the worst of its neighbours by Topology. New islands start from the last
+// of State.Generations, e.g. a seeded one. Step returns all islands as one
+// population, which is what the stopping rules look at.
+type Islands struct {
+	Count        int
+	Rates        []float64 // starting mutation rate per island, Engine.Mutation for all if empty
+	MigrateEvery int       // 0 never migrates
+	Migrants     int
+	Topology     string // TopologyRing, TopologyFull or TopologyRandom
+}
+
+func (s *Islands) Next(state *State) int {
+	for _, isl := range state.Islands {
+		if l := len(isl.Generations); l > 0 {
+			return isl.Generations[l-1].Number + 1
+		}
+	}
+	return NextNumber(state)
+}
+
+func (s *Islands) Step(e *Engine, state *State) (Generation, error) {
+	if len(state.Islands) == 0 {
+		for i := 0; i < s.Count; i++ {
+			r := e.Mutation
+			if len(s.Rates) > 0 {
+				r = s.Rates[i%len(s.Rates)]
+			}
+			state.Islands = append(state.Islands, Island{Number: i, Mutation: r})
+		}
+	} else if len(state.Islands) != s.Count {
+		log.Println("state has", len(state.Islands), "islands, ignoring an island count of", s.Count)
+		s.Count = len(state.Islands)
+	}
+
+	next := make([]Generation, len(state.Islands))
+	for i, isl := range state.Islands {
+		var current Generation
+		if l := len(isl.Generations); l > 0 {
+			current = Cull(isl.Generations[l-1])
+		} else if l := len(state.Generations); l > 0 {
+			current = Cull(state.Generations[l-1])
+		} else {
+			current.Number = -1
+		}
+		log.Println("island", i, "mutation", isl.Mutation)
+		next[i] = e.Breed(current, isl.Mutation)
+		if err := e.Evaluate(next[i]); err != nil {
+			return next[i], scoreError(next[i], err)
+		}
+		state.Islands[i].Mutation = e.Adapt(isl.Mutation, next[i])
+		next[i] = e.Replace(current, next[i])
+		sort.Sort(&next[i])
+	}
+
+	immigrants := make([]int, len(next))
+	if s.MigrateEvery > 0 && (next[0].Number+1)%s.MigrateEvery == 0 {
+		immigrants = migrate(next, s.Migrants, s.Topology)
+	}
+
+	whole := Generation{Number: next[0].Number, Objectives: next[0].Objectives}
+	for i := range state.Islands {
+		isl := &state.Islands[i]
+		isl.Generations = append(isl.Generations, next[i])
+		isl.Stats = append(isl.Stats, islandStats(next[i], immigrants[i]))
+		log.Printf("island %d gen %d %+v", i, next[i].Number, isl.Stats[len(isl.Stats)-1])
+		whole.Patches = append(whole.Patches, Cull(next[i]).Patches...)
+	}
+	sort.Sort(&whole)
+	return whole, nil
+}
+
+// migrate copies the top n individuals of each sorted generation over the
+// worst individuals of its neighbours, and returns how many each received.
+func migrate(gens []Generation, n int, topology string) []int {
+	received := make([]int, len(gens))
+	var migrants [][]ScoredPatch
+	for _, gen := range gens {
+		var m []ScoredPatch
+		for _, p := range gen.Patches {
+			if len(m) == n {
+				break
+			}
+			if !p.Filtered {
+				m = append(m, p)
+			}
+		}
+		migrants = append(migrants, m)
+	}
+	for from := range gens {
+		for _, to := range destinations(from, len(gens), topology) {
+			for _, p := range migrants[from] {
+				if receive(&gens[to], p) {
+					log.Println("migrating", p.Name, "from island", from, "to", to)
+					received[to]++
+				}
+			}
+		}
+	}
+	for i := range gens {
+		sort.Sort(&gens[i])
+	}
+	return received
+}
+
+func destinations(from, n int, topology string) []int {
+	if n < 2 {
+		return nil
+	}
+	switch topology {
+	case TopologyFull:
+		var out []int
+		for to := 0; to < n; to++ {
+			if to != from {
+				out = append(out, to)
+			}
+		}
+		return out
+	case TopologyRandom:
+		to := rand.Intn(n - 1)
+		if to >= from {
+			to++
+		}
+		return []int{to}
+	}
+	return []int{(from + 1) % n}
+}
+
+// receive puts p in place of the worst individual of gen if p scores better
+// and gen doesn't have it already.
+func receive(gen *Generation, p ScoredPatch) bool {
+	for _, q := range gen.Patches {
+		if q.ID == p.ID {
+			return false
+		}
+	}
+	sort.Sort(gen)
+	worst := len(gen.Patches) - 1
+	if worst < 0 || gen.Patches[worst].Score >= p.Score {
+		return false
+	}
+	gen.Patches[worst] = p
+	return true
+}
+
+func islandStats(gen Generation, immigrants int) IslandStats {
+	st := IslandStats{Generation: gen.Number, Immigrants: immigrants}
+	ids := make(map[string]bool)
+	for i, p := range gen.Patches {
+		if i == 0 || p.Score > st.Best {
+			st.Best = p.Score
+		}
+		st.Mean += p.Score
+		ids[p.ID] = true
+	}
+	if len(gen.Patches) > 0 {
+		st.Mean /= float64(len(gen.Patches))
+	}
+	st.Unique = len(ids)
+	return st
+}
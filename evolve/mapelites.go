@@ -0,0 +1,91 @@
+package evolve
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// MAPElites fills an Archive, kept in State.Archive, of patches binned by
+// the descriptors Describe computes, breeding each generation from parents
+// drawn uniformly from the archive at Engine.Mutation. A new archive is
+// seeded with every patch Describe can place that's already in the state.
+type MAPElites struct {
+	Descriptors []string // audio.Descriptors names, one per axis
+	Bins        int      // per axis
+	// Describe returns the descriptor values of a scored patch, in [0,1],
+	// or false if it has none, e.g. because its recording is gone.
+	Describe func(p ScoredPatch) ([]float64, bool)
+}
+
+func (s *MAPElites) Next(state *State) int {
+	return NextNumber(state)
+}
+
+// sameArchive reports whether archive was built with these settings.
+func (s *MAPElites) sameArchive(archive *Archive) bool {
+	return archive.Bins == s.Bins && strings.Join(archive.Descriptors, ",") == strings.Join(s.Descriptors, ",")
+}
+
+// insert offers p to the archive and reports whether it took a cell, either
+// empty or held by a worse patch.
+func (s *MAPElites) insert(archive *Archive, p ScoredPatch, number int) bool {
+	if p.Filtered {
+		return false
+	}
+	d, ok := s.Describe(p)
+	if !ok {
+		return false
+	}
+	cell := archive.Bin(d)
+	key := CellKey(cell)
+	if old, ok := archive.Cells[key]; ok && old.Score >= p.Score {
+		return false
+	}
+	p.Audio = nil // already in the cache
+	archive.Cells[key] = Elite{ScoredPatch: p, Cell: cell, Descriptors: d, Generation: number}
+	return true
+}
+
+func (s *MAPElites) Step(e *Engine, state *State) (Generation, error) {
+	if state.Archive == nil || !s.sameArchive(state.Archive) {
+		if state.Archive != nil {
+			log.Println("state archive is over", state.Archive.Descriptors, "with", state.Archive.Bins, "bins, starting a new one")
+		}
+		state.Archive = &Archive{Descriptors: s.Descriptors, Bins: s.Bins, Cells: make(map[string]Elite)}
+		for _, gen := range state.Generations {
+			for _, p := range gen.Patches {
+				s.insert(state.Archive, p, gen.Number)
+			}
+		}
+		log.Println("seeded archive with", len(state.Archive.Cells), "cells from earlier generations")
+	}
+
+	// offspring replace nothing, the archive does the selecting
+	b := *e
+	b.Selector = Uniform{}
+	b.Replacement = Comma
+	b.Lambda = e.PopSize
+
+	number := NextNumber(state)
+	parents := Generation{Number: number - 1}
+	for _, el := range state.Archive.Elites() {
+		parents.Patches = append(parents.Patches, el.ScoredPatch)
+	}
+	gen := b.Breed(parents, e.Mutation)
+	if err := e.Evaluate(gen); err != nil {
+		return gen, scoreError(gen, err)
+	}
+
+	added := 0
+	for _, p := range gen.Patches {
+		if s.insert(state.Archive, p, number) {
+			added++
+		}
+	}
+	log.Println("gen", number, "improved", added, "cells,", len(state.Archive.Cells), "occupied")
+
+	sort.Sort(&gen)
+	state.Generations = append(state.Generations, gen)
+	return gen, nil
+}
@@ -0,0 +1,121 @@
+package evolve
+
+import (
+	"math"
+	"sort"
+)
+
+// dominates reports whether a is at least as good as b on every objective
+// and better on at least one.
+func dominates(a, b []float64) bool {
+	better := false
+	for i := range a {
+		if a[i] < b[i] {
+			return false
+		}
+		if a[i] > b[i] {
+			better = true
+		}
+	}
+	return better
+}
+
+// nondominatedSort sets Rank on every patch and returns the fronts as
+// indices into patches, best front first.
+func nondominatedSort(patches []ScoredPatch) (fronts [][]int) {
+	dominatedBy := make([]int, len(patches))
+	dominatesList := make([][]int, len(patches))
+	var front []int
+	for i := range patches {
+		for j := range patches {
+			if i == j {
+				continue
+			}
+			if dominates(patches[i].Scores, patches[j].Scores) {
+				dominatesList[i] = append(dominatesList[i], j)
+			} else if dominates(patches[j].Scores, patches[i].Scores) {
+				dominatedBy[i]++
+			}
+		}
+		if dominatedBy[i] == 0 {
+			patches[i].Rank = 0
+			front = append(front, i)
+		}
+	}
+	for len(front) > 0 {
+		fronts = append(fronts, front)
+		var next []int
+		for _, i := range front {
+			for _, j := range dominatesList[i] {
+				if dominatedBy[j]--; dominatedBy[j] == 0 {
+					patches[j].Rank = len(fronts)
+					next = append(next, j)
+				}
+			}
+		}
+		front = next
+	}
+	return
+}
+
+// crowding sets the NSGA-II crowding distance on the patches in front.
+// Boundary patches get math.MaxFloat64 rather than +Inf so the state can
+// still be written out as JSON.
+func crowding(patches []ScoredPatch, front []int) {
+	for _, i := range front {
+		patches[i].Crowding = 0
+	}
+	if len(front) == 0 {
+		return
+	}
+	order := append([]int(nil), front...)
+	for m := range patches[front[0]].Scores {
+		sort.Slice(order, func(a, b int) bool {
+			return patches[order[a]].Scores[m] < patches[order[b]].Scores[m]
+		})
+		lo, hi := patches[order[0]].Scores[m], patches[order[len(order)-1]].Scores[m]
+		patches[order[0]].Crowding = math.MaxFloat64
+		patches[order[len(order)-1]].Crowding = math.MaxFloat64
+		if hi == lo {
+			continue
+		}
+		for k := 1; k < len(order)-1; k++ {
+			if patches[order[k]].Crowding == math.MaxFloat64 {
+				continue
+			}
+			patches[order[k]].Crowding += (patches[order[k+1]].Scores[m] - patches[order[k-1]].Scores[m]) / (hi - lo)
+		}
+	}
+}
+
+// nsgaSelect keeps the best n patches by front, breaking ties in the last
+// front that fits by crowding distance.
+func nsgaSelect(patches []ScoredPatch, n int) []ScoredPatch {
+	out := make([]ScoredPatch, 0, n)
+	for _, front := range nondominatedSort(patches) {
+		crowding(patches, front)
+		if len(out)+len(front) > n {
+			sort.Slice(front, func(a, b int) bool {
+				return patches[front[a]].Crowding > patches[front[b]].Crowding
+			})
+			front = front[:n-len(out)]
+		}
+		for _, i := range front {
+			out = append(out, patches[i])
+		}
+		if len(out) == n {
+			break
+		}
+	}
+	return out
+}
+
+// paretoFront lists the IDs of the non-dominated patches.
+func paretoFront(patches []ScoredPatch) (ids []string) {
+	for _, p := range patches {
+		if p.Rank == 0 {
+			ids = append(ids, p.ID)
+		}
+	}
+	return
+}
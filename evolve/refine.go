@@ -0,0 +1,134 @@
+package evolve
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+// Hill climbing moves for Climb.Moves
+const (
+	ClimbCoordinate = "coordinate" // one parameter up or down at a time
+	ClimbStochastic = "stochastic" // gaussian nudges to a few random parameters
+)
+
+// Climb configures Engine.Refine.
+type Climb struct {
+	Params    []int   // indices into midi.Params to climb over
+	Moves     string  // ClimbCoordinate or ClimbStochastic
+	Step      float64 // starting step, in the unit-cube units of midi.Patch.Vector
+	Steps     int     // most patches recorded
+	Threshold float64 // stop once the best patch scores at least this
+}
+
+// Refine hill climbs from start, recording every step and keeping it only
+// if it scores better. State is saved to the Store after each step. The
+// starting patch and each improvement on it are appended to the state as
+// one new generation, which is returned and passed to OnGeneration.
+func (e *Engine) Refine(state *State, start ScoredPatch, c Climb) ([]ScoredPatch, error) {
+	number := NextNumber(state)
+	tried := 0
+	try := func(p midi.Patch) (ScoredPatch, error) {
+		gen := Generation{Number: number, Patches: []ScoredPatch{{Patch: p, ID: p.Hash()}}}
+		NamePatch(&gen.Patches[0].Patch, number, tried)
+		tried++
+		if err := e.Evaluate(gen); err != nil {
+			return gen.Patches[0], scoreError(gen, err)
+		}
+		if e.Store != nil {
+			return gen.Patches[0], e.Store.Save(*state)
+		}
+		return gen.Patches[0], nil
+	}
+
+	best, err := try(start.Patch)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("refining", start.Name, "from score", best.Score, "over", len(c.Params), "parameters")
+	trail := []ScoredPatch{best}
+	x := SubsetVector(best.Patch, c.Params)
+	step := c.Step
+
+	improve := func(cand []float64) (bool, error) {
+		p := PatchFrom(best.Patch, c.Params, cand)
+		if p.Hash() == best.ID {
+			return false, nil
+		}
+		s, err := try(p)
+		if err != nil {
+			return false, err
+		}
+		if Fitness(s) <= Fitness(best) {
+			return false, nil
+		}
+		log.Println("step", tried, "improved score to", s.Score)
+		best, x = s, cand
+		trail = append(trail, s)
+		return true, nil
+	}
+
+	stuck := false
+	for !stuck && tried < c.Steps && best.Score < c.Threshold {
+		switch c.Moves {
+		case ClimbCoordinate:
+			// a sweep over every parameter in random order, halving the step
+			// after a sweep that found nothing
+			found := false
+			for _, k := range rand.Perm(len(c.Params)) {
+				if tried >= c.Steps {
+					break
+				}
+				param := midi.Params()[c.Params[k]]
+				delta := math.Max(step, 1/float64(param.Max-param.Min))
+				for _, dir := range []float64{1, -1} {
+					cand := append([]float64(nil), x...)
+					cand[k] = math.Max(0, math.Min(1, cand[k]+dir*delta))
+					ok, err := improve(cand)
+					if err != nil {
+						return nil, err
+					}
+					if ok {
+						found = true
+						break
+					}
+				}
+			}
+			if !found {
+				if step < 1.0/0x7f {
+					log.Println("no coordinate step improves on", best.Score)
+					stuck = true
+				}
+				step /= 2
+			}
+		case ClimbStochastic:
+			cand := append([]float64(nil), x...)
+			n := 1 + rand.Intn(int(math.Min(float64(len(cand)), 4)))
+			for _, k := range rand.Perm(len(cand))[:n] {
+				cand[k] = math.Max(0, math.Min(1, cand[k]+rand.NormFloat64()*step))
+			}
+			if _, err := improve(cand); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	gen := Generation{Number: number, Patches: trail}
+	for i := range gen.Patches {
+		NamePatch(&gen.Patches[i].Patch, number, i)
+	}
+	sort.Sort(&gen)
+	state.Generations = append(state.Generations, gen)
+	if e.Store != nil {
+		if err := e.Store.Save(*state); err != nil {
+			return gen.Patches, err
+		}
+	}
+	if e.OnGeneration != nil {
+		e.OnGeneration(gen)
+	}
+	return gen.Patches, nil
+}
@@ -0,0 +1,97 @@
+package evolve
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Tournament returns the best of Size individuals drawn with replacement.
+type Tournament struct {
+	Size int
+}
+
+func (t Tournament) Pick(patches []ScoredPatch) int {
+	best := rand.Intn(len(patches))
+	for k := 1; k < t.Size; k++ {
+		if c := rand.Intn(len(patches)); patches[c].Score > patches[best].Score {
+			best = c
+		}
+	}
+	return best
+}
+
+// Roulette picks with probability proportional to score. Scores are shifted
+// so the worst individual has weight 0, since 1 - distance goes negative.
+type Roulette struct{}
+
+func (Roulette) Pick(patches []ScoredPatch) int {
+	min := patches[0].Score
+	for _, p := range patches {
+		if p.Score < min {
+			min = p.Score
+		}
+	}
+	var total float64
+	for _, p := range patches {
+		total += p.Score - min
+	}
+	if total == 0 {
+		return rand.Intn(len(patches))
+	}
+	r := rand.Float64() * total
+	for i, p := range patches {
+		r -= p.Score - min
+		if r < 0 {
+			return i
+		}
+	}
+	return len(patches) - 1
+}
+
+// Rank picks with probability proportional to rank, the worst individual
+// having weight 1 and the best len(patches).
+type Rank struct{}
+
+func (Rank) Pick(patches []ScoredPatch) int {
+	order := make([]int, len(patches))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return patches[order[a]].Score < patches[order[b]].Score
+	})
+	n := len(patches)
+	r := rand.Intn(n * (n + 1) / 2)
+	for weight, i := range order {
+		r -= weight + 1
+		if r < 0 {
+			return i
+		}
+	}
+	return order[n-1]
+}
+
+// Uniform picks any individual with equal probability, which is how
+// MAP-Elites chooses parents from its archive.
+type Uniform struct{}
+
+func (Uniform) Pick(patches []ScoredPatch) int {
+	return rand.Intn(len(patches))
+}
+
+// CrowdedTournament is NSGA-II's binary tournament: lower front wins, then
+// larger crowding distance.
+type CrowdedTournament struct{}
+
+func (CrowdedTournament) Pick(patches []ScoredPatch) int {
+	a, b := rand.Intn(len(patches)), rand.Intn(len(patches))
+	switch {
+	case patches[a].Rank < patches[b].Rank:
+		return a
+	case patches[b].Rank < patches[a].Rank:
+		return b
+	case patches[b].Crowding > patches[a].Crowding:
+		return b
+	}
+	return a
+}
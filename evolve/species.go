@@ -0,0 +1,95 @@
+package evolve
+
+import (
+	"math"
+	"sort"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+// Diversity schemes for Engine.Species
+const (
+	Sharing  = "sharing"  // parents are selected by score shared out over their species
+	Crowding = "crowding" // offspring replace the nearest individual they beat
+)
+
+// AssignSpecies sets Species on every patch. Going from best to worst,
+// each patch joins the first species whose founder is within radius of it
+// or founds a new one. It returns the number of species.
+func AssignSpecies(patches []ScoredPatch, radius float64) int {
+	vectors := make([][]float64, len(patches))
+	for i := range patches {
+		vectors[i] = patches[i].Vector()
+	}
+	order := make([]int, len(patches))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return patches[order[a]].Score > patches[order[b]].Score })
+	var founders []int
+	for _, i := range order {
+		patches[i].Species = -1
+		for s, f := range founders {
+			if midi.VectorDistance(vectors[i], vectors[f]) <= radius {
+				patches[i].Species = s
+				break
+			}
+		}
+		if patches[i].Species < 0 {
+			patches[i].Species = len(founders)
+			founders = append(founders, i)
+		}
+	}
+	return len(founders)
+}
+
+// shared returns a copy of patches, with species assigned, whose scores
+// are shifted so the worst is 0 and then divided by the size of their
+// species. Selecting on it keeps one crowded niche from taking over.
+func shared(patches []ScoredPatch, radius float64) []ScoredPatch {
+	out := append([]ScoredPatch(nil), patches...)
+	if len(out) == 0 {
+		return out
+	}
+	AssignSpecies(out, radius)
+	size := make(map[int]int)
+	min := math.Inf(1)
+	for _, p := range out {
+		size[p.Species]++
+		min = math.Min(min, p.Score)
+	}
+	for i := range out {
+		out[i].Score = (out[i].Score - min) / float64(size[out[i].Species])
+	}
+	return out
+}
+
+// crowd is deterministic-crowding style replacement: each offspring takes
+// the place of the nearest survivor if it scores better, so niches are only
+// ever taken over by their own kind. Offspring fill any room left while
+// parents number fewer than popsize.
+func crowd(parents, offspring []ScoredPatch, popsize int) []ScoredPatch {
+	survivors := append([]ScoredPatch(nil), parents...)
+	vectors := make([][]float64, len(survivors))
+	for i := range survivors {
+		vectors[i] = survivors[i].Vector()
+	}
+	for _, child := range offspring {
+		v := child.Vector()
+		if len(survivors) < popsize {
+			survivors = append(survivors, child)
+			vectors = append(vectors, v)
+			continue
+		}
+		nearest, best := -1, math.Inf(1)
+		for i := range survivors {
+			if d := midi.VectorDistance(v, vectors[i]); d < best {
+				nearest, best = i, d
+			}
+		}
+		if nearest >= 0 && Fitness(child) > Fitness(survivors[nearest]) {
+			survivors[nearest], vectors[nearest] = child, v
+		}
+	}
+	return survivors
+}
@@ -0,0 +1,225 @@
+package evolve
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+// State is everything a run keeps between generations and runs: its
+// history, the source audio and the cache of recordings.
+type State struct {
+	Generations []Generation
+	SourceAudio []float32
+	Format      Format
+	Cache       map[string]Evaluation // keyed by ScoredPatch.ID
+	Islands     []Island              // sub-populations, used instead of Generations
+	CMAES       *CMAES                // set by the CMA strategy
+	Archive     *Archive              // set by the MAPElites strategy
+	StopReason  string                // why the last run stopped, empty while running or if interrupted
+	Rated       bool                  // scores are a listener's ratings, there is no SourceAudio
+	Runs        []Run                 // the settings of every run on this state, oldest first
+}
+
+// Format describes SourceAudio with the fields of libsndfile's SF_INFO, by
+// the same names, so state files saved with an sndfile.Info still load.
+type Format struct {
+	Frames     int64
+	Samplerate int32
+	Channels   int32
+	Format     int32
+	Sections   int32
+	Seekable   int32
+}
+
+// Run records the settings fevolver was started with.
+type Run struct {
+	Start    time.Time
+	Config   string            // the -config file verbatim, empty without one
+	Settings map[string]string // every flag's value after the config file and command line
+}
+
+// Archive is a MAP-Elites grid holding the best patch found for each
+// combination of descriptor bins.
+type Archive struct {
+	Descriptors []string         // audio.Descriptors names, one per axis
+	Bins        int              // per axis
+	Cells       map[string]Elite // keyed by CellKey
+}
+
+// Elite is the occupant of an Archive cell.
+type Elite struct {
+	ScoredPatch
+	Cell        []int
+	Descriptors []float64 // unbinned, one per Archive.Descriptors
+	Generation  int       // number of the generation it was found in
+}
+
+// CellKey formats cell indices as the Archive.Cells key, e.g. "3,7".
+func CellKey(cell []int) string {
+	s := make([]string, len(cell))
+	for i, c := range cell {
+		s[i] = strconv.Itoa(c)
+	}
+	return strings.Join(s, ",")
+}
+
+// Bin returns the cell a patch with the given descriptor values falls in.
+func (a Archive) Bin(descriptors []float64) []int {
+	cell := make([]int, len(descriptors))
+	for i, d := range descriptors {
+		cell[i] = int(d * float64(a.Bins))
+		if cell[i] >= a.Bins {
+			cell[i] = a.Bins - 1
+		} else if cell[i] < 0 {
+			cell[i] = 0
+		}
+	}
+	return cell
+}
+
+// Elites lists the occupied cells in order of their indices.
+func (a Archive) Elites() []Elite {
+	out := make([]Elite, 0, len(a.Cells))
+	for _, e := range a.Cells {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		for k := range out[i].Cell {
+			if out[i].Cell[k] != out[j].Cell[k] {
+				return out[i].Cell[k] < out[j].Cell[k]
+			}
+		}
+		return false
+	})
+	return out
+}
+
+// CMAES is the search distribution of a CMA-ES run, kept in the state so
+// the run can be resumed.
+type CMAES struct {
+	Params    []string   // midi.Param names searched, in vector order
+	Base      midi.Patch // supplies everything not in Params
+	Mean      []float64
+	Sigma     float64
+	Separable bool        // only the diagonal of the covariance is adapted
+	C         [][]float64 // covariance, or a single row holding the diagonal when Separable
+	Pc, Ps    []float64   // evolution paths
+	Updates   int
+}
+
+// Island is a sub-population that evolves with its own mutation rate and
+// swaps its best individuals with other islands now and then.
+type Island struct {
+	Number      int
+	Mutation    float64
+	Generations []Generation
+	Stats       []IslandStats // one per generation
+}
+
+type IslandStats struct {
+	Generation int
+	Best       float64
+	Mean       float64
+	Unique     int // distinct IDs in the generation
+	Immigrants int // individuals received by migration this generation
+}
+
+// Evaluation holds every recording made of one patch, so identical
+// individuals in later generations don't need to be played again.
+type Evaluation struct {
+	Scores         []float64   // one per recording
+	Filtered       bool        // from the latest recording
+	AudioFile      string      // wav file of the latest recording, kept out of the state to keep it small
+	Objectives     [][]float64 // objective vector per recording, in multi-objective runs
+	ObjectiveNames []string    // audio.Objectives names the vectors hold, in order
+	Patch          *midi.Patch // what was recorded, nil in caches from before surrogates
+}
+
+// ObjectiveMeans averages the objective vectors of all recordings.
+func (e Evaluation) ObjectiveMeans() []float64 {
+	if len(e.Objectives) == 0 {
+		return nil
+	}
+	out := make([]float64, len(e.Objectives[0]))
+	for _, v := range e.Objectives {
+		for i := range out {
+			out[i] += v[i]
+		}
+	}
+	for i := range out {
+		out[i] /= float64(len(e.Objectives))
+	}
+	return out
+}
+
+// Score is the mean of all recorded scores.
+func (e Evaluation) Score() float64 {
+	var sum float64
+	for _, s := range e.Scores {
+		sum += s
+	}
+	return sum / float64(len(e.Scores))
+}
+
+// StdDev estimates the recording noise from the spread of the scores.
+func (e Evaluation) StdDev() float64 {
+	if len(e.Scores) < 2 {
+		return 0
+	}
+	mean := e.Score()
+	var sum float64
+	for _, s := range e.Scores {
+		sum += (s - mean) * (s - mean)
+	}
+	return math.Sqrt(sum / float64(len(e.Scores)-1))
+}
+
+type ScoredPatch struct {
+	midi.Patch
+	ID       string // midi.Patch.Hash, stable across renames
+	Score    float64
+	Filtered bool
+	Audio    []float32
+
+	// Multi-objective runs only
+	Scores   []float64 // one per Generation.Objectives, higher is better
+	Rank     int       // Pareto front, 0 is non-dominated
+	Crowding float64   // NSGA-II crowding distance within the front
+
+	Species int // set with Engine.Species, patches within Engine.Radius of the same founder
+
+	Age   int // generations since the oldest of its genes was random
+	Layer int // ALPS age layer, 0 outside the ALPS strategy
+
+	MutationRate float64 // this individual's own rate with AdaptSelf
+}
+
+type Generation struct {
+	Number     int
+	Patches    []ScoredPatch
+	Objectives []string // audio.Objectives names, empty for single-objective runs
+	Front      []string // IDs of the patches with Rank 0
+	Layers     int      // ALPS layers the patches are split into by Layer, 0 outside the ALPS strategy
+
+	MutationRate float64 // the rate offspring were mutated at, their mean with AdaptSelf
+}
+
+func (g *Generation) Len() int {
+	// log.Println("Generation", g.Number, "has", len(g.Patches))
+	return len(g.Patches)
+}
+
+func (g *Generation) Less(i, j int) bool {
+	// log.Println(g.Patches[i].Score, ">", g.Patches[j].Score)
+	return g.Patches[i].Score > g.Patches[j].Score
+}
+
+func (g *Generation) Swap(i, j int) {
+	// log.Println("swap", i, g.Patches[i].Score, j, g.Patches[j].Score)
+	g.Patches[i], g.Patches[j] = g.Patches[j], g.Patches[i]
+}
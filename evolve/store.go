@@ -0,0 +1,28 @@
+package evolve
+
+import (
+	"encoding/gob"
+	"os"
+)
+
+// FileStore keeps the state gob-encoded in the named file.
+type FileStore string
+
+func (f FileStore) Load() (state State, err error) {
+	statefile, err := os.Open(string(f))
+	if err != nil {
+		return
+	}
+	defer statefile.Close()
+	err = gob.NewDecoder(statefile).Decode(&state)
+	return
+}
+
+func (f FileStore) Save(state State) error {
+	statefile, err := os.Create(string(f))
+	if err != nil {
+		return err
+	}
+	defer statefile.Close()
+	return gob.NewEncoder(statefile).Encode(state)
+}
@@ -0,0 +1,51 @@
+package evolve
+
+import "fmt"
+
+// Strategy is a search Engine.Run drives a generation at a time: GA, CMA,
+// DE, BO, MAPElites, ALPS or Islands. Each picks up from whatever state
+// holds, so a run can be stopped and resumed.
+type Strategy interface {
+	// Next is the number of the generation Step would make.
+	Next(state *State) int
+	// Step makes the next generation, scores it with Engine.Evaluate and
+	// adds it to state. It returns the generation for Done and the hooks.
+	Step(e *Engine, state *State) (Generation, error)
+}
+
+// GA is the genetic algorithm: each generation is bred from the last with
+// Engine.Breed, at a rate that follows Engine.Adaptation, and replaces it by
+// Engine.Replacement.
+type GA struct {
+	rate    float64
+	started bool
+}
+
+func (g *GA) Next(state *State) int {
+	return NextNumber(state)
+}
+
+func (g *GA) Step(e *Engine, state *State) (Generation, error) {
+	var last Generation
+	if l := len(state.Generations); l > 0 {
+		last = Cull(state.Generations[l-1])
+	} else {
+		last.Number = -1
+	}
+	if !g.started {
+		g.rate, g.started = e.InitialRate(e.Mutation, last), true
+	}
+	next := e.Breed(last, g.rate)
+	if err := e.Evaluate(next); err != nil {
+		return next, scoreError(next, err)
+	}
+	g.rate = e.Adapt(g.rate, next)
+
+	next = e.Replace(last, next)
+	state.Generations = append(state.Generations, next)
+	return next, nil
+}
+
+func scoreError(gen Generation, err error) error {
+	return fmt.Errorf("scoring generation %d: %v", gen.Number, err)
+}
@@ -0,0 +1,94 @@
+package evolve
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+// ParamSubset returns the indices into midi.Params whose names start with
+// one of the comma-separated prefixes, or all of them for an empty list.
+// The vector strategies search the parameters it picks.
+func ParamSubset(prefixes string) ([]int, error) {
+	var out []int
+	for i, p := range midi.Params() {
+		if prefixes == "" {
+			out = append(out, i)
+			continue
+		}
+		for _, prefix := range strings.Split(prefixes, ",") {
+			if strings.HasPrefix(p.Name, strings.TrimSpace(prefix)) {
+				out = append(out, i)
+				break
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no parameters match %q", prefixes)
+	}
+	return out, nil
+}
+
+// subsetNames maps indices from ParamSubset back to parameter names.
+func subsetNames(subset []int) []string {
+	names := make([]string, len(subset))
+	for k, i := range subset {
+		names[k] = midi.Params()[i].Name
+	}
+	return names
+}
+
+// sameParams reports whether subset names the same parameters as names.
+func sameParams(subset []int, names []string) bool {
+	if len(subset) != len(names) {
+		return false
+	}
+	for k, n := range subsetNames(subset) {
+		if names[k] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// SubsetVector picks the entries of p.Vector listed in subset.
+func SubsetVector(p midi.Patch, subset []int) []float64 {
+	full := p.Vector()
+	out := make([]float64, len(subset))
+	for k, i := range subset {
+		out[k] = full[i]
+	}
+	return out
+}
+
+// PatchFrom returns base with the parameters in subset set from x.
+func PatchFrom(base midi.Patch, subset []int, x []float64) midi.Patch {
+	full := base.Vector()
+	for k, i := range subset {
+		full[i] = x[k]
+	}
+	return base.FromVector(full)
+}
+
+// BestScored returns the best unfiltered patch in the latest generation of
+// state, or false if there isn't one.
+func BestScored(state *State) (best ScoredPatch, ok bool) {
+	if len(state.Generations) == 0 {
+		return
+	}
+	for _, p := range state.Generations[len(state.Generations)-1].Patches {
+		if !p.Filtered && (!ok || p.Score > best.Score) {
+			best, ok = p, true
+		}
+	}
+	return
+}
+
+// NextNumber is the number the next generation appended to state gets.
+func NextNumber(state *State) int {
+	if l := len(state.Generations); l > 0 {
+		return state.Generations[l-1].Number + 1
+	}
+	return 0
+}
@@ -0,0 +1,214 @@
+package midi
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Activity records which parts of a Patch can currently be heard. Part i
+// always plays Voices[i] (Mutate pins ProgramNumber to the part index), so
+// the per-voice entries are indexed the same way as Voices.
+type Activity struct {
+	// Fseq is false when FseqPart is 0, which makes the FSEQ data and the
+	// PerfCommon fields tagged active:"fseq" inert.
+	Fseq bool
+	// Filter[i] is false when Parts[i].FilterSw is off, which makes the
+	// VoiceCommon fields tagged active:"filter" inert.
+	Filter [4]bool
+	// Voiced[i][op] is false when the op is switched off in
+	// FseqVoicedOpSwitch or its total level is 0.
+	Voiced [4][8]bool
+	// Unvoiced[i][op] is false when the op is switched off in
+	// FseqUnvoicedOpSwitch or its level is 0.
+	Unvoiced [4][8]bool
+}
+
+// opSwitch reads an op's bit out of a Hi/Lo switch pair. Op 8 lives in bit 0
+// of Hi, ops 1-7 in bits 0-6 of Lo.
+func opSwitch(hi, lo int8, op int) bool {
+	if op == 7 {
+		return hi&1 != 0
+	}
+	return lo&(1<<uint(op)) != 0
+}
+
+func (p Patch) Activity() (a Activity) {
+	a.Fseq = p.FseqPart != 0
+	for i := range p.Voices {
+		v := &p.Voices[i]
+		a.Filter[i] = p.Parts[i].FilterSw != 0
+		for op := range v.VoicedParams {
+			a.Voiced[i][op] = opSwitch(v.FseqVoicedOpSwitchHi, v.FseqVoicedOpSwitchLo, op) &&
+				v.VoicedParams[op].LvlScalingTotal != 0
+		}
+		for op := range v.UnvoicedParams {
+			a.Unvoiced[i][op] = opSwitch(v.FseqUnvoicedOpSwitchHi, v.FseqUnvoicedOpSwitchLo, op) &&
+				v.UnvoicedParams[op].Lvl != 0
+		}
+	}
+	return
+}
+
+// InertFields lists the fields of p that currently have no audible effect,
+// as Go selector paths relative to the Patch (e.g. "Voices[0].VoicedParams[3]").
+func (p Patch) InertFields() (out []string) {
+	a := p.Activity()
+	if !a.Fseq {
+		out = append(out, "FSEQ")
+		out = append(out, taggedFields(reflect.TypeOf(p.PerfCommon), "PerfCommon", "fseq")...)
+	}
+	for i := range p.Voices {
+		prefix := fmt.Sprintf("Voices[%d]", i)
+		if !a.Filter[i] {
+			out = append(out, taggedFields(reflect.TypeOf(p.Voices[i].VoiceCommon), prefix+".VoiceCommon", "filter")...)
+		}
+		for op, on := range a.Voiced[i] {
+			if !on {
+				out = append(out, fmt.Sprintf("%s.VoicedParams[%d]", prefix, op))
+			}
+		}
+		for op, on := range a.Unvoiced[i] {
+			if !on {
+				out = append(out, fmt.Sprintf("%s.UnvoicedParams[%d]", prefix, op))
+			}
+		}
+	}
+	return
+}
+
+func taggedFields(t reflect.Type, prefix, group string) (out []string) {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("active") == group {
+			out = append(out, prefix+"."+t.Field(i).Name)
+		}
+	}
+	return
+}
+
+// MutateActive is like Mutate, but leaves the parameters that p.Activity
+// reports as inert untouched. pm is scaled up so that the expected number of
+// changed parameters is the same as Mutate would make over the whole patch,
+// and applies to array elements too, which Mutate redraws regardless.
+func MutateActive(p Patch, pm float64) (out Patch) {
+	a := p.Activity()
+	if active := a.genes(p); active > 0 {
+		pm *= float64(p.genes()) / float64(active)
+		if pm > 1 {
+			pm = 1
+		}
+	}
+	var skip string
+	if !a.Fseq {
+		skip = "fseq"
+	}
+	out.PerfCommon = mutateStruct(reflect.ValueOf(p.PerfCommon), pm, pm, skip).(PerfCommon)
+	for i := range out.Parts {
+		out.Parts[i].ProgramNumber = int8(i)
+	}
+	for i := range out.Voices {
+		out.Voices[i] = mutateVoice(p.Voices[i], pm, a, i)
+	}
+	if out.PerfCommon.FseqPart != 0 {
+		if a.Fseq {
+			out.FSEQ = p.FSEQ.Mutate(pm).(FSEQ)
+		} else {
+			// FSEQ was just switched on, nothing in p.FSEQ was ever heard
+			out.FSEQ = p.FSEQ.Mutate(1).(FSEQ)
+		}
+	}
+	return
+}
+
+func mutateVoice(v Voice, pm float64, a Activity, i int) (out Voice) {
+	var skip string
+	if !a.Filter[i] {
+		skip = "filter"
+	}
+	out.VoiceCommon = mutateStruct(reflect.ValueOf(v.VoiceCommon), pm, pm, skip).(VoiceCommon)
+	for op := range v.VoicedParams {
+		if a.Voiced[i][op] {
+			out.VoicedParams[op] = mutateStruct(reflect.ValueOf(v.VoicedParams[op]), pm, pm, "").(VoicedOp)
+		} else {
+			out.VoicedParams[op] = v.VoicedParams[op]
+		}
+	}
+	for op := range v.UnvoicedParams {
+		if a.Unvoiced[i][op] {
+			out.UnvoicedParams[op] = mutateStruct(reflect.ValueOf(v.UnvoicedParams[op]), pm, pm, "").(UnvoicedOp)
+		} else {
+			out.UnvoicedParams[op] = v.UnvoicedParams[op]
+		}
+	}
+	return
+}
+
+// genes counts the parameters Mutate can change in p.
+func (p Patch) genes() int {
+	n := genes(reflect.ValueOf(p.PerfCommon), "") + genes(reflect.ValueOf(p.Voices), "")
+	if p.FseqPart != 0 {
+		n += genes(reflect.ValueOf(p.FSEQ), "")
+	}
+	return n
+}
+
+// genes counts the parameters MutateActive can change in p.
+func (a Activity) genes(p Patch) int {
+	var n int
+	if a.Fseq {
+		n += genes(reflect.ValueOf(p.PerfCommon), "") + genes(reflect.ValueOf(p.FSEQ), "")
+	} else {
+		n += genes(reflect.ValueOf(p.PerfCommon), "fseq")
+	}
+	for i := range p.Voices {
+		v := &p.Voices[i]
+		if a.Filter[i] {
+			n += genes(reflect.ValueOf(v.VoiceCommon), "")
+		} else {
+			n += genes(reflect.ValueOf(v.VoiceCommon), "filter")
+		}
+		for op := range v.VoicedParams {
+			if a.Voiced[i][op] {
+				n += genes(reflect.ValueOf(v.VoicedParams[op]), "")
+			}
+		}
+		for op := range v.UnvoicedParams {
+			if a.Unvoiced[i][op] {
+				n += genes(reflect.ValueOf(v.UnvoicedParams[op]), "")
+			}
+		}
+	}
+	return n
+}
+
+// genes counts the mutatable values in rv, leaving out fields tagged
+// active:"<skip>" and int8 fields whose min and max are equal.
+func genes(rv reflect.Value, skip string) (n int) {
+	switch v := rv.Interface().(type) {
+	case ReservedBits:
+		return 0
+	case Int14:
+		return 1
+	case Bitmaps:
+		return len(v) * len(v[0])
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			fieldtype := rv.Type().Field(i)
+			if skip != "" && fieldtype.Tag.Get("active") == skip {
+				continue
+			}
+			if fieldtype.Type.Kind() == reflect.Int8 && parseField(fieldtype, "min", 0) == parseField(fieldtype, "max", 0x7f) {
+				continue
+			}
+			n += genes(rv.Field(i), skip)
+		}
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			n += genes(rv.Index(i), skip)
+		}
+	case reflect.Int8:
+		n = 1
+	}
+	return
+}
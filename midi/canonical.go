@@ -0,0 +1,77 @@
+package midi
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+)
+
+// Canonical returns a copy of p with everything that can't be heard zeroed:
+// names, ReservedBits and the fields reported by InertFields. Two patches
+// that sound the same have the same canonical form.
+func (p Patch) Canonical() Patch {
+	a := p.Activity()
+	out := p
+	out.FseqFrames = append([]FseqFrame(nil), p.FseqFrames...)
+	zeroUnheard(reflect.ValueOf(&out).Elem())
+	if !a.Fseq {
+		out.FSEQ = FSEQ{}
+		zeroTagged(reflect.ValueOf(&out.PerfCommon).Elem(), "fseq")
+	}
+	for i := range out.Voices {
+		v := &out.Voices[i]
+		if !a.Filter[i] {
+			zeroTagged(reflect.ValueOf(&v.VoiceCommon).Elem(), "filter")
+		}
+		for op := range v.VoicedParams {
+			if !a.Voiced[i][op] {
+				v.VoicedParams[op] = VoicedOp{}
+			}
+		}
+		for op := range v.UnvoicedParams {
+			if !a.Unvoiced[i][op] {
+				v.UnvoicedParams[op] = UnvoicedOp{}
+			}
+		}
+	}
+	return out
+}
+
+// Hash is a hex digest of the sysex for p's canonical form. It's stable
+// across renames and changes to inert parameters.
+func (p Patch) Hash() string {
+	h := sha256.New()
+	for _, m := range p.Canonical().Msgs() {
+		h.Write(m)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// zeroUnheard clears every string and ReservedBits reachable from rv, which
+// must be addressable.
+func zeroUnheard(rv reflect.Value) {
+	if rv.Type() == reflect.TypeOf(ReservedBits(0)) {
+		rv.SetUint(0)
+		return
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString("")
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			zeroUnheard(rv.Field(i))
+		}
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			zeroUnheard(rv.Index(i))
+		}
+	}
+}
+
+func zeroTagged(rv reflect.Value, group string) {
+	for i := 0; i < rv.NumField(); i++ {
+		if rv.Type().Field(i).Tag.Get("active") == group {
+			rv.Field(i).Set(reflect.Zero(rv.Field(i).Type()))
+		}
+	}
+}
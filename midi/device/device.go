@@ -0,0 +1,70 @@
+// Package device sends patches and notes to MIDI outputs through portmidi.
+// It's kept apart from package midi so that builds without portmidi can
+// still handle patches.
+package device
+
+import (
+	"log"
+
+	"github.com/mkb218/fevolver/midi"
+
+	"github.com/rakyll/portmidi"
+)
+
+// Stream is a midi.Output on a portmidi device.
+type Stream struct {
+	*portmidi.Stream
+}
+
+func init() {
+	err := portmidi.Initialize()
+	if err != nil {
+		log.Println("error initializing portmidi", err)
+	}
+	log.Println("portmidi initialized")
+}
+
+func GetDevices() (devs []*portmidi.DeviceInfo) {
+	dev_count := portmidi.CountDevices()
+	devs = make([]*portmidi.DeviceInfo, dev_count)
+	for i := 0; i < dev_count; i++ {
+		devinfo := portmidi.Info(portmidi.DeviceID(i))
+		devs[i] = devinfo
+	}
+	return
+}
+
+func OpenStream(output portmidi.DeviceID) (*Stream, error) {
+	// log.Println("opening device", output)
+	s, err := portmidi.NewOutputStream(output, 1024, 0)
+	// log.Println(s, err)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{s}, nil
+}
+
+func (b *Stream) SendPatch(p midi.Patch) (e error) {
+	msgs := p.Msgs()
+	for i, m := range msgs {
+		// log.Printf("%x", m[len(m)-9:])
+		// log.Println("trying to write", len(m), "bytes")
+		e = b.Stream.WriteSysExBytes(0, m)
+		if e != nil {
+			log.Println("message was ", m)
+			log.Println("Error writing msg", i, ":", e)
+			return
+		}
+	}
+	return nil
+}
+
+// Write sends events to the device straight away, whatever their
+// Timestamps.
+func (b *Stream) Write(events []midi.Event) error {
+	out := make([]portmidi.Event, len(events))
+	for i, e := range events {
+		out[i] = portmidi.Event{Status: e.Status, Data1: e.Data1, Data2: e.Data2}
+	}
+	return b.Stream.Write(out)
+}
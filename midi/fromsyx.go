@@ -104,23 +104,22 @@ func FromByteArray(totalbuf []byte) (outp *Patch, err error) {
 			if ck := checksum(header[4:], data, footer[0:1]); ck != 0 {
 				log.Printf("Bad checksum! %x != %x", ck, 0)
 			}
-			var leftoverdata []byte
 			switch datatype {
 			case perfcommonaddr:
-				leftoverdata = fromBytes(data, reflect.ValueOf(&(p.PerfCommon)), 0)
+				err = decode(data, &p.PerfCommon)
 			case voice1addr:
-				leftoverdata = fromBytes(data, reflect.ValueOf(&(p.Voices[0])), 0)
+				err = decode(data, &p.Voices[0])
 			case voice2addr:
-				leftoverdata = fromBytes(data, reflect.ValueOf(&(p.Voices[1])), 0)
+				err = decode(data, &p.Voices[1])
 			case voice3addr:
-				leftoverdata = fromBytes(data, reflect.ValueOf(&(p.Voices[2])), 0)
+				err = decode(data, &p.Voices[2])
 			case voice4addr:
-				leftoverdata = fromBytes(data, reflect.ValueOf(&(p.Voices[3])), 0)
+				err = decode(data, &p.Voices[3])
 			default:
 				return nil, fmt.Errorf("unknown datatype %x", datatype)
 			}
-			if len(leftoverdata) > 0 {
-				return nil, fmt.Errorf("Failed to consume all data %x", leftoverdata)
+			if err != nil {
+				return nil, err
 			}
 		} else {
 			// log.Println("left in buffer before fseq", br.Len())
@@ -145,7 +144,11 @@ func FromByteArray(totalbuf []byte) (outp *Patch, err error) {
 
 }
 
-const fseqheaderlen = 32
+const (
+	fseqheaderlen    = 32
+	fseqformatoffset = 27 // of FrameDataFormat in the FSEQ header
+	fseqframelen     = 50
+)
 
 func fseqFromBytes(reader *bytes.Reader, fseq *FSEQ) (checksum byte, err error) {
 	header := make([]byte, fseqheaderlen)
@@ -191,7 +194,7 @@ func fseqFromBytes(reader *bytes.Reader, fseq *FSEQ) (checksum byte, err error)
 
 	fseq.FseqFrames = make([]FseqFrame, int(fseq.FrameDataFormat+1)*128)
 	for i := range fseq.FseqFrames {
-		data := make([]byte, 50)
+		data := make([]byte, fseqframelen)
 		n, err = reader.Read(data)
 		for _, i := range data {
 			checksum += byte(i)
@@ -203,9 +206,8 @@ func fseqFromBytes(reader *bytes.Reader, fseq *FSEQ) (checksum byte, err error)
 			err = fmt.Errorf("error read from fseq frame data %v %v", i, err)
 			return
 		}
-		leftover := fromBytes(data, reflect.ValueOf(&(fseq.FseqFrames[i])), 0)
-		if len(leftover) > 0 {
-			err = fmt.Errorf("didn't consume all data from fseq %d", i)
+		if err = decode(data, &fseq.FseqFrames[i]); err != nil {
+			err = fmt.Errorf("fseq frame %d: %v", i, err)
 			return
 		}
 	}
@@ -213,12 +215,30 @@ func fseqFromBytes(reader *bytes.Reader, fseq *FSEQ) (checksum byte, err error)
 	return
 }
 
+// decode fills the struct v points to from all of data, returning an error
+// where fromBytes would panic.
+func decode(data []byte, v interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("can't decode %d bytes into %T: %v", len(data), v, r)
+		}
+	}()
+	if left := fromBytes(data, reflect.ValueOf(v), 0); len(left) > 0 {
+		return fmt.Errorf("%d bytes left over decoding %T", len(left), v)
+	}
+	return nil
+}
+
 func fromBytes(data []byte, rv reflect.Value, size int) []byte {
 	// log.Printf("%v %v %v %.16x", rv.Type(), len(data), cap(data), data)
 	defer func() {
 		// log.Printf("(%v)", rv.Elem().Interface())
 		if r := recover(); r != nil {
-			log.Panicln(data[:16], rv, r)
+			head := data
+			if len(head) > 16 {
+				head = head[:16]
+			}
+			log.Panicln(head, rv, r)
 		}
 	}()
 	if rv.Type().Kind() != reflect.Ptr {
@@ -289,3 +309,124 @@ func fromBytes(data []byte, rv reflect.Value, size int) []byte {
 	log.Panicln("This should be unreachable!")
 	return nil
 }
+
+// Bank addresses, with the program number in the low byte
+const (
+	perfbankaddr  = 0x110000
+	voicebankaddr = 0x510000
+)
+
+// PatchesFromSYXFile reads every patch in a .syx file. See PatchesFromSYX.
+func PatchesFromSYXFile(filename string) ([]Patch, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return PatchesFromSYX(buf)
+}
+
+// PatchesFromSYX reads an edit buffer dump, as written by Patch.Msgs, and
+// internal performance banks. A bank performance gets its voices from the
+// internal voices in the same dump; parts whose voice isn't there are
+// silenced. Fields this package pins are pinned, so the patches play the
+// same way generated ones do.
+func PatchesFromSYX(buf []byte) (out []Patch, err error) {
+	var edit, fseq []byte
+	var perfs []PerfCommon
+	voices := make(map[int]Voice)
+	for len(buf) > 0 {
+		end := bytes.IndexByte(buf, 0xf7)
+		if end < 0 {
+			return nil, fmt.Errorf("%d bytes after the last message", len(buf))
+		}
+		msg := buf[:end+1]
+		buf = buf[end+1:]
+		if len(msg) < HeaderLen+FooterLen {
+			return nil, fmt.Errorf("short message %x", msg)
+		}
+		if msg[0] != 0xf0 || msg[1] != 0x43 || msg[3] != 0x5e {
+			return nil, fmt.Errorf("not an FS1r bulk dump: %x", msg[:4])
+		}
+		addr := int(msg[6])<<16 | int(msg[7])<<8 | int(msg[8])
+		data := msg[HeaderLen : len(msg)-FooterLen]
+		if want := dataLen(addr, data); want >= 0 && len(data) != want {
+			return nil, fmt.Errorf("message for address %x has %d bytes of data, want %d", addr, len(data), want)
+		}
+		switch {
+		case addr == fseqaddr:
+			fseq = append(fseq, msg...)
+		case addr == perfcommonaddr || (addr >= voice1addr && addr <= voice4addr):
+			edit = append(edit, msg...)
+		case addr&^0xff == perfbankaddr:
+			var pc PerfCommon
+			if err := decode(data, &pc); err != nil {
+				return nil, fmt.Errorf("performance %d: %v", addr&0xff, err)
+			}
+			perfs = append(perfs, pc)
+		case addr&^0xff == voicebankaddr:
+			var v Voice
+			if err := decode(data, &v); err != nil {
+				return nil, fmt.Errorf("voice %d: %v", addr&0xff, err)
+			}
+			voices[addr&0xff] = v
+		default:
+			log.Printf("skipping message for address %x", addr)
+		}
+	}
+
+	if len(edit) > 0 {
+		// FromByteArray wants the FSEQ last
+		p, err := FromByteArray(append(edit, fseq...))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pin(*p))
+	}
+	for _, pc := range perfs {
+		p := Patch{PerfCommon: pc}
+		for i, part := range pc.Parts {
+			v, ok := voices[int(part.ProgramNumber)]
+			if part.VoiceBankNumber != 1 || !ok {
+				p.Parts[i].Volume = 0
+				continue
+			}
+			p.Voices[i] = v
+		}
+		// bank performances don't come with their FSEQ
+		p.FseqPart = 0
+		out = append(out, pin(p))
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no edit buffer or bank performances found")
+	}
+	return out, nil
+}
+
+// dataLen is how many data bytes a message for addr must carry, or -1 for
+// addresses PatchesFromSYX skips.
+func dataLen(addr int, data []byte) int {
+	switch {
+	case addr == perfcommonaddr || addr&^0xff == perfbankaddr:
+		return PerfCommonLen
+	case addr == voice1addr || addr == voice2addr || addr == voice3addr || addr == voice4addr,
+		addr&^0xff == voicebankaddr:
+		return VoiceParamLen
+	case addr == fseqaddr:
+		if len(data) < fseqheaderlen {
+			return fseqheaderlen
+		}
+		format := int(data[fseqformatoffset])
+		if format > 3 {
+			// fseqFromBytes says what's wrong with it
+			return len(data)
+		}
+		return fseqheaderlen + (format+1)*128*fseqframelen
+	}
+	return -1
+}
+
+// pin sets the fields that min and max tags fix, including which voice
+// each part plays, and brings the rest into range.
+func pin(p Patch) Patch {
+	return p.FromVector(p.Vector())
+}
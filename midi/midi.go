@@ -5,23 +5,12 @@ import (
 	"math/rand"
 	"reflect"
 	"strconv"
-
-	"github.com/rakyll/portmidi"
 )
 
-type Stream struct {
-	*portmidi.Stream
-}
-
 var mutatorInt = reflect.TypeOf(new(Mutatable)).Elem()
 
 func init() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	err := portmidi.Initialize()
-	if err != nil {
-		log.Println("error initializing portmidi", err)
-	}
-	log.Println("portmidi initialized")
 }
 
 const (
@@ -167,12 +156,12 @@ func adjustInternals(numParts int, buf []byte) []byte {
 }
 
 func Mutate(p Patch, pm float64) (out Patch) {
-	out.PerfCommon = mutateStruct(reflect.ValueOf(p.PerfCommon), pm).(PerfCommon)
+	out.PerfCommon = mutateStruct(reflect.ValueOf(p.PerfCommon), pm, 1, "").(PerfCommon)
 	for i := range out.Parts {
 		out.Parts[i].ProgramNumber = int8(i)
 	}
 	for i := range out.Voices {
-		out.Voices[i] = mutateStruct(reflect.ValueOf(p.Voices[i]), pm).(Voice)
+		out.Voices[i] = mutateStruct(reflect.ValueOf(p.Voices[i]), pm, 1, "").(Voice)
 	}
 	if out.PerfCommon.FseqPart != 0 {
 		out.FSEQ = p.FSEQ.Mutate(pm).(FSEQ)
@@ -199,7 +188,11 @@ func mutateInt8(min, max int8) int8 {
 	return int8(rand.Int63n(int64(max-min)) + int64(min))
 }
 
-func mutateStruct(rv reflect.Value, pm float64) interface{} {
+// mutateStruct returns a copy of rv with each parameter replaced by a random
+// value with probability pm, except for the int8 elements of arrays, which
+// are replaced with probability elempm. Mutate redraws them all. Fields
+// tagged active:"<skip>" are copied as-is.
+func mutateStruct(rv reflect.Value, pm, elempm float64, skip string) interface{} {
 	t := rv.Type()
 	if t.Kind() != reflect.Struct {
 		log.Panic("only should ever be called on structs or Mutatables")
@@ -218,6 +211,10 @@ func mutateStruct(rv reflect.Value, pm float64) interface{} {
 	for i = 0; i < rv.Type().NumField(); i++ {
 		fieldval := rv.Field(i)
 		fieldtype := rv.Type().Field(i)
+		if skip != "" && fieldtype.Tag.Get("active") == skip {
+			out.Elem().Field(i).Set(fieldval)
+			continue
+		}
 		if m, ok := fieldval.Interface().(Mutatable); ok {
 			out.Elem().Field(i).Set(reflect.ValueOf(m.Mutate(pm)))
 			continue
@@ -242,9 +239,13 @@ func mutateStruct(rv reflect.Value, pm float64) interface{} {
 				}
 				switch fieldtype.Type.Elem().Kind() {
 				case reflect.Int8:
-					elem.Elem().Index(e).SetInt(int64(mutateInt8(min, max)))
+					if choice := rand.Float64(); choice > elempm {
+						elem.Elem().Index(e).Set(fieldval.Index(e))
+					} else {
+						elem.Elem().Index(e).SetInt(int64(mutateInt8(min, max)))
+					}
 				case reflect.Struct:
-					elem.Elem().Index(e).Set(reflect.ValueOf(mutateStruct(fieldval.Index(e), pm)))
+					elem.Elem().Index(e).Set(reflect.ValueOf(mutateStruct(fieldval.Index(e), pm, elempm, "")))
 				default:
 					log.Panicln("couldn't mutate", fieldtype)
 				}
@@ -253,7 +254,7 @@ func mutateStruct(rv reflect.Value, pm float64) interface{} {
 		case reflect.String:
 			out.Elem().Field(i).SetString("")
 		case reflect.Struct:
-			out.Elem().Field(i).Set(reflect.ValueOf(mutateStruct(fieldval, pm)))
+			out.Elem().Field(i).Set(reflect.ValueOf(mutateStruct(fieldval, pm, elempm, skip)))
 		default:
 			log.Panicln("Couldn't figure out how to mutate", fieldtype)
 		}
@@ -367,38 +368,3 @@ func reflectBytes(pr reflect.Value) (out []byte) {
 	}
 	return out
 }
-
-func GetDevices() (devs []*portmidi.DeviceInfo) {
-	dev_count := portmidi.CountDevices()
-	devs = make([]*portmidi.DeviceInfo, dev_count)
-	for i := 0; i < dev_count; i++ {
-		devinfo := portmidi.Info(portmidi.DeviceID(i))
-		devs[i] = devinfo
-	}
-	return
-}
-
-func OpenStream(output portmidi.DeviceID) (*Stream, error) {
-	// log.Println("opening device", output)
-	s, err := portmidi.NewOutputStream(output, 1024, 0)
-	// log.Println(s, err)
-	if err != nil {
-		return nil, err
-	}
-	return &Stream{s}, nil
-}
-
-func (b *Stream) SendPatch(p Patch) (e error) {
-	msgs := p.Msgs()
-	for i, m := range msgs {
-		// log.Printf("%x", m[len(m)-9:])
-		// log.Println("trying to write", len(m), "bytes")
-		e = b.Stream.WriteSysExBytes(0, m)
-		if e != nil {
-			log.Println("message was ", m)
-			log.Println("Error writing msg", i, ":", e)
-			return
-		}
-	}
-	return nil
-}
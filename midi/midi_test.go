@@ -1,8 +1,11 @@
 package midi
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -82,3 +85,258 @@ func TestFromSYX(t *testing.T) {
 // 		child1, child2, err := Crossover(&mom, &dad)
 // 	}
 // }
+
+func TestActivity(t *testing.T) {
+	p := RandomPatch()
+	p.FseqPart = 0
+	for i := range p.Parts {
+		p.Parts[i].FilterSw = 1
+		p.Voices[i].FseqVoicedOpSwitchHi, p.Voices[i].FseqVoicedOpSwitchLo = 1, 0x7f
+		p.Voices[i].FseqUnvoicedOpSwitchHi, p.Voices[i].FseqUnvoicedOpSwitchLo = 1, 0x7f
+		for op := range p.Voices[i].VoicedParams {
+			p.Voices[i].VoicedParams[op].LvlScalingTotal = 0x40
+			p.Voices[i].UnvoicedParams[op].Lvl = 0x40
+		}
+	}
+	p.Parts[1].FilterSw = 0
+	p.Voices[2].FseqVoicedOpSwitchLo &^= 1 << 3
+	p.Voices[3].UnvoicedParams[7].Lvl = 0
+
+	a := p.Activity()
+	if a.Fseq || a.Filter[1] || !a.Filter[0] || a.Voiced[2][3] || !a.Voiced[2][2] || a.Unvoiced[3][7] {
+		t.Fatalf("wrong activity %+v", a)
+	}
+	inert := make(map[string]bool)
+	for _, f := range p.InertFields() {
+		inert[f] = true
+	}
+	for _, f := range []string{"FSEQ", "PerfCommon.FseqLoopMode", "Voices[1].VoiceCommon.FilterCutoffFreq",
+		"Voices[2].VoicedParams[3]", "Voices[3].UnvoicedParams[7]"} {
+		if !inert[f] {
+			t.Error("expected", f, "to be inert")
+		}
+	}
+	if len(inert) != 3+len(taggedFields(reflect.TypeOf(p.PerfCommon), "", "fseq"))+
+		len(taggedFields(reflect.TypeOf(p.Voices[1].VoiceCommon), "", "filter")) {
+		t.Error("unexpected inert fields", p.InertFields())
+	}
+
+	out := MutateActive(p, 1)
+	if out.Voices[1].FilterCutoffFreq != p.Voices[1].FilterCutoffFreq ||
+		out.Voices[2].VoicedParams[3] != p.Voices[2].VoicedParams[3] ||
+		out.Voices[3].UnvoicedParams[7] != p.Voices[3].UnvoicedParams[7] ||
+		out.FseqSpeedRatio != p.FseqSpeedRatio || out.FseqStartStepOffset != p.FseqStartStepOffset {
+		t.Error("MutateActive changed an inert parameter")
+	}
+	if q := MutateActive(p, 0); q.Hash() != p.Hash() {
+		t.Error("MutateActive with probability 0 changed the patch")
+	}
+}
+
+func TestHash(t *testing.T) {
+	p := RandomPatch()
+	p.FseqPart = 0
+	p.Parts[0].FilterSw = 0
+	q := p
+	q.Name = "RENAMED"
+	q.Voices[2].Name = "RENAMED"
+	q.FseqLoopMode ^= 1
+	q.Voices[0].FilterCutoffFreq ^= 1
+	if p.Hash() != q.Hash() {
+		t.Error("names and inert parameters changed the hash")
+	}
+	if c := p.Canonical(); c.Hash() != p.Hash() || !reflect.DeepEqual(c, c.Canonical()) {
+		t.Error("Canonical isn't idempotent")
+	}
+	q.Parts[0].FilterSw = 1
+	if p.Hash() == q.Hash() {
+		t.Error("switching the filter on didn't change the hash")
+	}
+}
+
+func TestVector(t *testing.T) {
+	p := RandomPatch()
+	v := p.Vector()
+	if len(v) != len(Params()) {
+		t.Fatal("vector has", len(v), "entries but there are", len(Params()), "params")
+	}
+	for i, x := range v {
+		if x < 0 || x > 1 {
+			t.Error(Params()[i].Name, "out of range:", x)
+		}
+	}
+	q := p.FromVector(v)
+	if !reflect.DeepEqual(p.Canonical(), q.Canonical()) {
+		t.Error("FromVector(Vector()) changed the patch")
+	}
+	for i := range v {
+		v[i] = 1 - v[i]
+	}
+	q = p.FromVector(v)
+	if q.FseqPart != 0 && len(q.FseqFrames) != int(q.FrameDataFormat+1)*128 {
+		t.Error("FromVector left", len(q.FseqFrames), "frames for frame data format", q.FrameDataFormat)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	p, q := RandomPatch(), RandomPatch()
+	if d := Distance(p, p); d != 0 {
+		t.Error("patch is", d, "from itself")
+	}
+	d := Distance(p, q)
+	if d <= 0 || d > 1 {
+		t.Error("random patches are", d, "apart")
+	}
+	if Distance(q, p) != d {
+		t.Error("distance isn't symmetric")
+	}
+}
+
+func TestPatchesFromSYX(t *testing.T) {
+	p := RandomPatch()
+	p.FseqPart = 0
+	p.Voices[2].Name = "Bank voice" // names come back padded to their length
+	var buf []byte
+	for _, m := range p.Msgs() {
+		buf = append(buf, m...)
+	}
+	bank := p
+	bank.Parts[0].ProgramNumber = 9
+	bank.Parts[1].ProgramNumber = 10
+	buf = append(buf, envelope(perfbankaddr|3, reflectBytes(reflect.ValueOf(bank.PerfCommon)))...)
+	buf = append(buf, envelope(voicebankaddr|9, reflectBytes(reflect.ValueOf(p.Voices[2])))...)
+
+	patches, err := PatchesFromSYX(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 2 {
+		t.Fatal("got", len(patches), "patches, want 2")
+	}
+	if patches[0].Hash() != p.Hash() {
+		t.Error("edit buffer patch changed")
+	}
+	b := patches[1]
+	if !reflect.DeepEqual(b.Voices[0], p.Voices[2]) {
+		t.Error("part 1 didn't get voice 9")
+	}
+	if b.Parts[1].Volume != 0 {
+		t.Error("part 2 plays a voice that isn't in the bank")
+	}
+	if b.Parts[0].ProgramNumber != 0 || b.Parts[1].ProgramNumber != 1 {
+		t.Error("program numbers aren't pinned")
+	}
+}
+
+func TestPatchesFromBadSYX(t *testing.T) {
+	p := RandomPatch()
+	var msgs [][]byte
+	for _, m := range p.Msgs() {
+		msgs = append(msgs, m)
+	}
+	join := func(ms ...[]byte) []byte {
+		var buf []byte
+		for _, m := range ms {
+			buf = append(buf, m...)
+		}
+		return buf
+	}
+	foreign := append([]byte(nil), msgs[0]...)
+	foreign[1] = 0x41
+	othermodel := append([]byte(nil), msgs[1]...)
+	othermodel[3] = 0x4c
+	voice := reflectBytes(reflect.ValueOf(p.Voices[0]))
+	perf := reflectBytes(reflect.ValueOf(p.PerfCommon))
+	for name, buf := range map[string][]byte{
+		"other manufacturer":  join(foreign),
+		"other model":         join(othermodel),
+		"short edit voice":    join(msgs[0], envelope(voice1addr, voice[:20])),
+		"long edit voice":     join(msgs[0], envelope(voice1addr, append(voice, 0))),
+		"short bank voice":    envelope(voicebankaddr|1, voice[:VoiceParamLen-1]),
+		"short performance":   envelope(perfbankaddr, perf[:3]),
+		"short fseq":          join(msgs[0], envelope(fseqaddr, make([]byte, 10))),
+		"truncated last msg":  msgs[0][:len(msgs[0])-1],
+		"header only message": {0xf0, 0x43, 0, 0x5e, 0, 0, 0x10, 0, 0, 0, 0xf7},
+	} {
+		if _, err := PatchesFromSYX(buf); err == nil {
+			t.Error(name, "was read without an error")
+		}
+	}
+}
+
+func TestPrior(t *testing.T) {
+	base := RandomPatch()
+	base.Parts[3].Volume = 0
+	pr, err := NewPrior([]Patch{base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr.BlockRate = 1
+	p := pr.Random()
+	if !reflect.DeepEqual(p.PerfCommon.Parts[0], base.PerfCommon.Parts[0]) {
+		t.Error("part 1 wasn't copied from the only corpus patch")
+	}
+	if !reflect.DeepEqual(p.Voices[0].VoicedParams, base.Voices[0].VoicedParams) {
+		t.Error("voice 1 operators weren't copied from the only corpus patch")
+	}
+	if q := pr.Mutate(base, 0); q.Hash() != base.Hash() {
+		t.Error("mutating with probability 0 changed the patch")
+	}
+
+	if _, err := NewPrior(nil); err == nil {
+		t.Error("learned a prior from nothing")
+	}
+
+	wild := base
+	wild.Voices[0].VoicedParams[0].OscFreqCoarse = 0x7f
+	wild.Voices[0].VoicedParams[1].OscFreqCoarse = -1
+	if _, err := NewPrior([]Patch{wild}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileOutput(t *testing.T) {
+	dir := t.TempDir()
+	p := RandomPatch()
+	var sysex []byte
+	for _, m := range p.Msgs() {
+		sysex = append(sysex, m...)
+	}
+	notes := []Event{{Status: 0x90, Data1: 64, Data2: 100}, {Status: 0x80, Data1: 64, Data2: 100}}
+
+	for _, name := range []string{"out.syx", "out.mid"} {
+		var out Output
+		out, err := NewFileOutput(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = out.SendPatch(p); err != nil {
+			t.Fatal(err)
+		}
+		if err = out.Write(notes); err != nil {
+			t.Fatal(err)
+		}
+		if err = out.Close(); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "out.syx" {
+			if !bytes.Equal(buf, sysex) {
+				t.Error(name, "doesn't hold just the patch's messages")
+			}
+			continue
+		}
+		if !bytes.HasPrefix(buf, []byte("MThd")) || !bytes.Contains(buf, []byte("MTrk")) {
+			t.Error(name, "isn't a standard MIDI file")
+		}
+		if !bytes.Contains(buf, p.Msgs()[0][1:]) || !bytes.Contains(buf, []byte{0x90, 64, 100}) {
+			t.Error(name, "is missing the sysex or the note on")
+		}
+		if !bytes.HasSuffix(buf, []byte{0xff, 0x2f, 0x00}) {
+			t.Error(name, "track doesn't end")
+		}
+	}
+}
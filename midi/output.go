@@ -0,0 +1,129 @@
+package midi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Event is a short MIDI message, a note on or off say.
+type Event struct {
+	Timestamp    int64 // milliseconds to delay it by in a FileOutput, devices send it now
+	Status       int64
+	Data1, Data2 int64
+}
+
+// Output is what patches and notes are sent to: a device, through
+// device.Stream, or a file, through FileOutput.
+type Output interface {
+	SendPatch(p Patch) error
+	Write(events []Event) error
+	Close() error
+}
+
+// FileOutput is an Output that needs no device. A .mid or .midi file gets
+// a format 0 standard MIDI file of everything sent, timed as it was sent,
+// at a millisecond per tick, or by the Timestamps of Events, which place
+// them after the event before. Any other file gets the sysex messages as a
+// .syx file would hold them, and note events are dropped.
+type FileOutput struct {
+	f       *os.File
+	smf     bool
+	start   time.Time
+	last    int64        // milliseconds from start to the previous event
+	track   bytes.Buffer // SMF events, written out by Close
+	dropped bool         // whether dropping notes has been logged
+}
+
+// NewFileOutput creates filename, choosing the format by its extension.
+func NewFileOutput(filename string) (*FileOutput, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	o := &FileOutput{f: f, smf: ext == ".mid" || ext == ".midi", start: time.Now()}
+	if o.smf {
+		// a quarter note is a second long, so a tick is a millisecond
+		o.event([]byte{0xff, 0x51, 0x03, 0x0f, 0x42, 0x40}, 0)
+	}
+	return o, nil
+}
+
+// event appends an SMF event, delta time first, delay milliseconds after
+// now or the previous event, whichever is later.
+func (o *FileOutput) event(data []byte, delay int64) {
+	now := time.Since(o.start).Milliseconds()
+	if now < o.last {
+		now = o.last
+	}
+	now += delay
+	o.track.Write(varLen(now - o.last))
+	o.last = now
+	o.track.Write(data)
+}
+
+// varLen encodes n as an SMF variable-length quantity.
+func varLen(n int64) []byte {
+	out := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		out = append([]byte{byte(n&0x7f) | 0x80}, out...)
+	}
+	return out
+}
+
+func (o *FileOutput) SendPatch(p Patch) error {
+	for _, m := range p.Msgs() {
+		if !o.smf {
+			if _, err := o.f.Write(m); err != nil {
+				return err
+			}
+			continue
+		}
+		// the F0 is the event type, followed by the length of the rest
+		o.event(append(append([]byte{0xf0}, varLen(int64(len(m)-1))...), m[1:]...), 0)
+	}
+	return nil
+}
+
+func (o *FileOutput) Write(events []Event) error {
+	if !o.smf {
+		if !o.dropped {
+			log.Println("dropping note events, only", filepath.Ext(o.f.Name()), "sysex is written to", o.f.Name())
+			o.dropped = true
+		}
+		return nil
+	}
+	for _, e := range events {
+		msg := []byte{byte(e.Status), byte(e.Data1 & 0x7f), byte(e.Data2 & 0x7f)}
+		if s := e.Status & 0xf0; s == 0xc0 || s == 0xd0 {
+			// program change and channel pressure have one data byte
+			msg = msg[:2]
+		}
+		o.event(msg, e.Timestamp)
+	}
+	return nil
+}
+
+// Close writes out the SMF, if that's the format, and closes the file.
+func (o *FileOutput) Close() error {
+	if o.smf {
+		o.event([]byte{0xff, 0x2f, 0x00}, 0)
+		var buf bytes.Buffer
+		buf.WriteString("MThd")
+		binary.Write(&buf, binary.BigEndian, []uint32{6})
+		binary.Write(&buf, binary.BigEndian, []uint16{0, 1, 1000})
+		buf.WriteString("MTrk")
+		binary.Write(&buf, binary.BigEndian, uint32(o.track.Len()))
+		buf.Write(o.track.Bytes())
+		if _, err := o.f.Write(buf.Bytes()); err != nil {
+			o.f.Close()
+			return err
+		}
+	}
+	return o.f.Close()
+}
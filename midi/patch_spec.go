@@ -74,7 +74,7 @@ type FseqHeader struct {
 }
 
 func (f FSEQ) Mutate(pm float64) Mutatable {
-	newheader := mutateStruct(reflect.ValueOf(f.FseqHeader), pm).(FseqHeader)
+	newheader := mutateStruct(reflect.ValueOf(f.FseqHeader), pm, 1, "").(FseqHeader)
 	// log.Println("framedata format", newheader.FrameDataFormat)
 	newframes := make([]FseqFrame, int(newheader.FrameDataFormat+1)*128)
 	// log.Println("frames", len(newframes))
@@ -85,7 +85,7 @@ func (f FSEQ) Mutate(pm float64) Mutatable {
 		} else {
 			in = reflect.ValueOf(FseqFrame{})
 		}
-		newframes[i] = mutateStruct(in, pm).(FseqFrame)
+		newframes[i] = mutateStruct(in, pm, 1, "").(FseqFrame)
 	}
 	return FSEQ{newheader, newframes}
 }
@@ -150,24 +150,24 @@ type VoiceCommon struct {
 	FormantControlDepth            [5]int8
 	FMControlDestination           [5]FControlDest
 	FMControlDepth                 [5]int8
-	FilterType                     int8 `max:"0x5"`
-	FilterRez                      int8 `max:"0x74"`
-	FilterRezVeloSens              int8 `max:"0xe"`
-	FilterCutoffFreq               int8
-	FilterEGDepthVelSens           int8
-	FilterCutoffFreqLFO1Depth      int8 `max:"0x63"`
-	FilterCutoffFreqLFO2Depth      int8 `max:"0x63"`
-	FilterCutoffFreqKeyScaleDepth  int8
-	FilterCutoffFreqKeyScalePoint  int8
-	FilterInputGain                int8 `max:"0x18"`
+	FilterType                     int8 `max:"0x5" active:"filter"`
+	FilterRez                      int8 `max:"0x74" active:"filter"`
+	FilterRezVeloSens              int8 `max:"0xe" active:"filter"`
+	FilterCutoffFreq               int8 `active:"filter"`
+	FilterEGDepthVelSens           int8 `active:"filter"`
+	FilterCutoffFreqLFO1Depth      int8 `max:"0x63" active:"filter"`
+	FilterCutoffFreqLFO2Depth      int8 `max:"0x63" active:"filter"`
+	FilterCutoffFreqKeyScaleDepth  int8 `active:"filter"`
+	FilterCutoffFreqKeyScalePoint  int8 `active:"filter"`
+	FilterInputGain                int8 `max:"0x18" active:"filter"`
 	Pad6                           [6]ReservedBits
-	FilterEGDepth                  int8
+	FilterEGDepth                  int8 `active:"filter"`
 	FilterEGLvl4, FilterEGLvl1,
-	FilterEGLvl2, FilterEGLvl3 int8 `max:"0x64"`
+	FilterEGLvl2, FilterEGLvl3 int8 `max:"0x64" active:"filter"`
 	FilterEGTime1, FilterEGTime2,
-	FilterEGTime3, FilterEGTime4 int8 `max:"0x64"`
+	FilterEGTime3, FilterEGTime4 int8 `max:"0x64" active:"filter"`
 	Pad7                           ReservedBits
-	FilterEGAttackTimeVelTimeScale int8 `max:"0x3f"`
+	FilterEGAttackTimeVelTimeScale int8 `max:"0x3f" active:"filter"`
 	Pad8                           ReservedBits
 }
 
@@ -236,7 +236,7 @@ type Voice struct {
 type PerfPart struct {
 	NoteReserve           int8 `max:"0x20"`
 	VoiceBankNumber       int8 `min:"1" max:"1"`
-	ProgramNumber         int8
+	ProgramNumber         int8 `vector:"-"` // pinned to the part index
 	RcvChannelMax         int8 `min:"0x7f" max:"0x7f"`
 	RcvChannel            int8 `min:"0x10" max:"0x10"`
 	MonoPoly              int8 `min:"1" max:"1"` // always poly
@@ -294,20 +294,20 @@ type PerfCommon struct {
 	PerfNoteShift                 int8            `max:"0x30"`
 	Pad2                          [2]ReservedBits // includes individual out
 	FseqPart                      int8            `max:"0x4"`
-	FseqBank                      int8            `max:"0x0" min:"0x0"` // always 0
-	FseqNumber                    ReservedBits    // always 0!
-	FseqSpeedRatio                Int14
-	FseqStartStepOffset           [2]int8
-	FseqStartStepLoopPoint        [2]int8
-	FseqEndStepLoopPoint          [2]int8
-	FseqLoopMode                  int8 `max:"0x1"`
-	FseqPlayMode                  int8 `min:"0x1" max:"0x2"`
-	FseqVelocitySensitivity       int8 `max:"0x7"`
-	FseqFormatPitchMode           int8 `max:"0x1"`
-	FseqKeyOnTrigger              int8 `max:"0x1"`
+	FseqBank                      int8            `max:"0x0" min:"0x0" active:"fseq"` // always 0
+	FseqNumber                    ReservedBits    `active:"fseq"`                     // always 0!
+	FseqSpeedRatio                Int14           `active:"fseq"`
+	FseqStartStepOffset           [2]int8         `active:"fseq"`
+	FseqStartStepLoopPoint        [2]int8         `active:"fseq"`
+	FseqEndStepLoopPoint          [2]int8         `active:"fseq"`
+	FseqLoopMode                  int8            `max:"0x1" active:"fseq"`
+	FseqPlayMode                  int8            `min:"0x1" max:"0x2" active:"fseq"`
+	FseqVelocitySensitivity       int8            `max:"0x7" active:"fseq"`
+	FseqFormatPitchMode           int8            `max:"0x1" active:"fseq"`
+	FseqKeyOnTrigger              int8            `max:"0x1" active:"fseq"`
 	Pad3                          ReservedBits
-	FseqFormantSequenceDelay      int8 `max:"0x63"`
-	FseqLevelVelocitySenstivity   int8
+	FseqFormantSequenceDelay      int8    `max:"0x63" active:"fseq"`
+	FseqLevelVelocitySenstivity   int8    `active:"fseq"`
 	ControllerPartSwitches        [8]int8 `max:"0xf"`
 	ControllerSourceSwitchBitmaps Bitmaps
 	ControllerDestinations        [8]int8 `max:"0x2f"`
@@ -0,0 +1,180 @@
+package midi
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Prior is a distribution over patches learned from a corpus, such as a
+// directory of factory banks. Each parameter has a histogram of the values
+// the corpus uses, and parameters that belong together (a part, a voice's
+// common section, one operator) form a block that can be copied whole from
+// a corpus patch, which keeps the correlations inside it.
+type Prior struct {
+	// BlockRate is the probability that a block being redrawn is copied
+	// from a corpus patch rather than drawn parameter by parameter.
+	BlockRate float64
+
+	weights [][]float64 // per parameter, per value from Param.Min
+	blocks  [][]int     // Params indexes, in order
+	vectors [][]float64 // the corpus
+	heard   [][]bool    // per corpus patch, per block
+}
+
+// DefaultBlockRate is the BlockRate NewPrior starts with.
+const DefaultBlockRate = 0.5
+
+// NewPrior learns a prior from corpus. Voices on parts with no volume
+// don't count, since they aren't part of the sound. Values out of range are
+// counted as the nearest legal one.
+func NewPrior(corpus []Patch) (*Prior, error) {
+	if len(corpus) == 0 {
+		return nil, fmt.Errorf("no patches to learn a prior from")
+	}
+	pr := &Prior{BlockRate: DefaultBlockRate, weights: make([][]float64, len(params))}
+	block_of := make([]int, len(params))
+	index := make(map[string]int)
+	for i, p := range params {
+		b := p.Name[:strings.LastIndex(p.Name, ".")]
+		n, ok := index[b]
+		if !ok {
+			n = len(pr.blocks)
+			index[b] = n
+			pr.blocks = append(pr.blocks, nil)
+		}
+		pr.blocks[n] = append(pr.blocks[n], i)
+		block_of[i] = n
+
+		// every value keeps a little weight, so nothing is impossible
+		pr.weights[i] = make([]float64, p.Max-p.Min+1)
+		for v := range pr.weights[i] {
+			pr.weights[i][v] = 1 / float64(len(pr.weights[i]))
+		}
+	}
+
+	for _, p := range corpus {
+		// Vector doesn't clamp, patches straight from a .syx may be out of range
+		p = pin(p)
+		v := p.Vector()
+		heard := make([]bool, len(pr.blocks))
+		for b, idx := range pr.blocks {
+			heard[b] = voiceHeard(p, params[idx[0]].Name)
+		}
+		for i, x := range v {
+			if heard[block_of[i]] {
+				pr.weights[i][int(x*float64(params[i].Max-params[i].Min)+0.5)]++
+			}
+		}
+		pr.vectors = append(pr.vectors, v)
+		pr.heard = append(pr.heard, heard)
+	}
+	return pr, nil
+}
+
+// voiceHeard reports whether the parameter name is outside the voices or in
+// the voice of a part with some volume.
+func voiceHeard(p Patch, name string) bool {
+	if !strings.HasPrefix(name, "Voices[") {
+		return true
+	}
+	i, err := strconv.Atoi(name[len("Voices["):strings.Index(name, "]")])
+	if err != nil {
+		panic(err)
+	}
+	return p.Parts[i].Volume > 0
+}
+
+// PriorFromDir learns a prior from the patches of every .syx file under
+// dir. Files that can't be read are logged and skipped.
+func PriorFromDir(dir string) (*Prior, error) {
+	var corpus []Patch
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".syx") {
+			return err
+		}
+		patches, err := PatchesFromSYXFile(path)
+		if err != nil {
+			log.Println("skipping", path+":", err)
+			return nil
+		}
+		corpus = append(corpus, patches...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewPrior(corpus)
+}
+
+// Random draws a patch from the prior, like RandomPatch draws one from
+// uniform distributions.
+func (pr *Prior) Random() Patch {
+	return pr.Mutate(Patch{}, 1)
+}
+
+// Mutate redraws each parameter of p from the prior with probability pm,
+// except that a block with any parameter to redraw is, with probability
+// BlockRate, copied whole from a corpus patch instead.
+func (pr *Prior) Mutate(p Patch, pm float64) Patch {
+	v := p.Vector()
+	for b, idx := range pr.blocks {
+		var redraw []int
+		for _, i := range idx {
+			if rand.Float64() < pm {
+				redraw = append(redraw, i)
+			}
+		}
+		if len(redraw) == 0 {
+			continue
+		}
+		if rand.Float64() < pr.BlockRate {
+			if src, ok := pr.source(b); ok {
+				for _, i := range idx {
+					v[i] = src[i]
+				}
+				continue
+			}
+		}
+		for _, i := range redraw {
+			v[i] = pr.draw(i)
+		}
+	}
+	return p.FromVector(v)
+}
+
+// source picks a corpus patch in which block b is heard.
+func (pr *Prior) source(b int) ([]float64, bool) {
+	var heard [][]float64
+	for k, v := range pr.vectors {
+		if pr.heard[k][b] {
+			heard = append(heard, v)
+		}
+	}
+	if len(heard) == 0 {
+		return nil, false
+	}
+	return heard[rand.Intn(len(heard))], true
+}
+
+// draw samples parameter i from its histogram, as a vector entry.
+func (pr *Prior) draw(i int) float64 {
+	w := pr.weights[i]
+	var total float64
+	for _, x := range w {
+		total += x
+	}
+	r := rand.Float64() * total
+	v := 0
+	for ; v < len(w)-1; v++ {
+		if r < w[v] {
+			break
+		}
+		r -= w[v]
+	}
+	return float64(v) / float64(len(w)-1)
+}
@@ -0,0 +1,129 @@
+package midi
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Param describes one entry of the vector returned by Patch.Vector.
+type Param struct {
+	Name     string // Go selector path relative to the Patch
+	Min, Max int
+}
+
+var params = func() (out []Param) {
+	var p Patch
+	walkParams(reflect.ValueOf(&p).Elem(), "", 0, 0x7f, func(name string, min, max int, _ reflect.Value) {
+		if min != max {
+			out = append(out, Param{name, min, max})
+		}
+	})
+	return
+}()
+
+// Params lists the entries of Patch.Vector in order. Names, ReservedBits,
+// fields pinned by their min and max tags and the FSEQ frames are left out.
+func Params() []Param {
+	return params
+}
+
+// Vector maps p onto [0,1] per parameter using the min and max tags.
+func (p Patch) Vector() []float64 {
+	out := make([]float64, 0, len(params))
+	walkParams(reflect.ValueOf(&p).Elem(), "", 0, 0x7f, func(_ string, min, max int, v reflect.Value) {
+		if min != max {
+			out = append(out, (float64(v.Int())-float64(min))/float64(max-min))
+		}
+	})
+	return out
+}
+
+// FromVector returns a copy of p with its parameters set from v, which must
+// be laid out as Params. Values are clamped to [0,1] and rounded to the
+// nearest legal setting. Names and FSEQ frames come from p; frames are
+// generated or resized if the new header calls for it.
+func (p Patch) FromVector(v []float64) Patch {
+	if len(v) != len(params) {
+		panic(fmt.Sprintf("vector has %d entries, patches have %d parameters", len(v), len(params)))
+	}
+	out := p
+	i := 0
+	walkParams(reflect.ValueOf(&out).Elem(), "", 0, 0x7f, func(_ string, min, max int, f reflect.Value) {
+		if min == max {
+			f.SetInt(int64(min))
+			return
+		}
+		x := math.Max(0, math.Min(1, v[i]))
+		f.SetInt(int64(math.Round(float64(min) + x*float64(max-min))))
+		i++
+	})
+	for i := range out.Parts {
+		out.Parts[i].ProgramNumber = int8(i)
+	}
+	if out.FseqPart == 0 {
+		out.FseqFrames = nil
+	} else if len(out.FseqFrames) != int(out.FrameDataFormat+1)*128 {
+		name := out.FSEQ.Name
+		out.FSEQ = out.FSEQ.Mutate(0).(FSEQ)
+		out.FSEQ.Name = name
+	} else {
+		out.FseqFrames = append([]FseqFrame(nil), out.FseqFrames...)
+	}
+	return out
+}
+
+// walkParams calls fn with every settable parameter reachable from rv in
+// vector order, including pinned ones. min and max are the tags of the
+// enclosing field and apply to arrays element by element.
+func walkParams(rv reflect.Value, name string, min, max int, fn func(name string, min, max int, v reflect.Value)) {
+	switch rv.Interface().(type) {
+	case ReservedBits:
+		return
+	case Int14:
+		fn(name, 0, 0x3fff, rv)
+		return
+	case FSEQ:
+		// frames are too many to search, only the header goes in the vector
+		walkParams(rv.Field(0), name+".FseqHeader", 0, 0x7f, fn)
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			fieldtype := rv.Type().Field(i)
+			if fieldtype.Tag.Get("vector") == "-" {
+				continue
+			}
+			fieldname := fieldtype.Name
+			if name != "" {
+				fieldname = name + "." + fieldname
+			}
+			walkParams(rv.Field(i), fieldname, int(parseField(fieldtype, "min", 0)), int(parseField(fieldtype, "max", 0x7f)), fn)
+		}
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			walkParams(rv.Index(i), name+"["+strconv.Itoa(i)+"]", min, max, fn)
+		}
+	case reflect.Int8:
+		fn(name, min, max, rv)
+	}
+}
+
+// Distance is the mean absolute difference between the vectors of a and b:
+// 0 for patches with the same parameters, 1 for patches at opposite ends
+// of every range.
+func Distance(a, b Patch) float64 {
+	return VectorDistance(a.Vector(), b.Vector())
+}
+
+// VectorDistance is Distance for vectors already taken, to save walking
+// patches that are compared many times.
+func VectorDistance(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += math.Abs(a[i] - b[i])
+	}
+	return sum / float64(len(a))
+}
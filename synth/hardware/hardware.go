@@ -0,0 +1,120 @@
+// Package hardware plays patches on an FS1r and records it.
+package hardware
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mkb218/fevolver/audio"
+	"github.com/mkb218/fevolver/midi"
+	"github.com/mkb218/fevolver/midi/device"
+
+	"github.com/rakyll/portmidi"
+)
+
+// FS1r is a synth.Synth on a MIDI output, recorded from an audio input.
+type FS1r struct {
+	out        midi.Output
+	audioDev   int // negative to play without recording
+	sampleRate float64
+	// Settle is how long Load waits for the synth to take in a patch.
+	Settle time.Duration
+}
+
+// Open opens the MIDI output midiDev. A negative audioDev makes Play return
+// no audio, for when someone is listening instead.
+func Open(midiDev, audioDev int, sampleRate float64) (*FS1r, error) {
+	stream, err := device.OpenStream(portmidi.DeviceID(midiDev))
+	if err != nil {
+		return nil, err
+	}
+	return New(stream, audioDev, sampleRate), nil
+}
+
+// New plays on out, which Close closes, like Open does on a device. A
+// midi.FileOutput has no synth to wait for, so Settle is 0.
+func New(out midi.Output, audioDev int, sampleRate float64) *FS1r {
+	h := &FS1r{out: out, audioDev: audioDev, sampleRate: sampleRate, Settle: 10 * time.Second}
+	if _, ok := out.(*midi.FileOutput); ok {
+		h.Settle = 0
+	}
+	return h
+}
+
+func (h *FS1r) Load(p midi.Patch) error {
+	if err := h.out.SendPatch(p); err != nil {
+		return err
+	}
+	if h.Settle > 0 {
+		log.Println("sleeping for bulk download")
+		time.Sleep(h.Settle)
+	}
+	return nil
+}
+
+func (h *FS1r) Play(note, velocity int8, length time.Duration) ([]float32, error) {
+	noteon := []midi.Event{{Timestamp: 0, Status: 0x90, Data1: int64(note & 0x7f), Data2: int64(velocity & 0x7f)}}
+	noteoff := []midi.Event{{Timestamp: 0, Status: 0x80, Data1: int64(note & 0x7f), Data2: int64(velocity & 0x7f)}}
+	if h.audioDev < 0 {
+		if err := h.out.Write(noteon); err != nil {
+			return nil, err
+		}
+		time.Sleep(length)
+		return nil, h.out.Write(noteoff)
+	}
+	return h.record(noteon, noteoff, length), nil
+}
+
+// record plays the note while recording, starting both together.
+func (h *FS1r) record(noteon, noteoff []midi.Event, rectime time.Duration) []float32 {
+	var ready sync.WaitGroup
+	var complete sync.WaitGroup
+	var locker = new(sync.RWMutex)
+	ready.Add(2)
+	complete.Add(2)
+	locker.Lock()
+	go func() {
+		ready.Done()
+		locker.RLock()
+		defer locker.RUnlock()
+		log.Println("noteon")
+		err := h.out.Write(noteon)
+		if err != nil {
+			log.Panic(err)
+		}
+		<-time.After(rectime)
+		err = h.out.Write(noteoff)
+		if err != nil {
+			log.Panicln(err)
+		}
+		log.Println("noteoff")
+		complete.Done()
+	}()
+
+	var device *audio.Iface
+	go func() {
+		var err error
+		device, err = audio.GetIface(h.audioDev, h.sampleRate, 512)
+		if err != nil {
+			log.Panic(err)
+		}
+		ready.Done()
+		locker.RLock()
+		defer locker.RUnlock()
+		log.Println("recording")
+		err = device.RecordAudio(rectime)
+		if err != nil {
+			log.Panicln(err)
+		}
+		complete.Done()
+	}()
+	ready.Wait()
+	locker.Unlock()
+	complete.Wait()
+	return device.Buffer
+}
+
+func (h *FS1r) Close() error {
+	return h.out.Close()
+}
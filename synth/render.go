@@ -0,0 +1,316 @@
+package synth
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+// Renderer approximates an FS1r in software, so runs can be made without
+// one. Each part's voice is rendered from its Patch fields: voiced ops are
+// sine operators in FM stacks, unvoiced ops are noise through a formant
+// resonator, and the part filter follows. It's a sketch of the real thing:
+// the FS1r's 88 algorithms are reduced to AlgoPreset picking how many
+// stacks the 8 voiced ops form, and the LFOs, pitch EG and FSEQ are
+// left out.
+type Renderer struct {
+	SampleRate float64
+	// Seed starts the noise of the unvoiced ops over on every Load, so a
+	// patch sounds the same however often it's played.
+	Seed  int64
+	patch midi.Patch
+	rng   *rand.Rand
+}
+
+func NewRenderer(sampleRate float64) *Renderer {
+	return &Renderer{SampleRate: sampleRate, Seed: 1}
+}
+
+func (r *Renderer) Load(p midi.Patch) error {
+	r.patch = p
+	r.rng = rand.New(rand.NewSource(r.Seed))
+	return nil
+}
+
+func (r *Renderer) Play(note, velocity int8, length time.Duration) ([]float32, error) {
+	if r.rng == nil {
+		r.rng = rand.New(rand.NewSource(r.Seed))
+	}
+	n := int(length.Seconds() * r.SampleRate)
+	out := make([]float32, 2*n)
+	p := r.patch
+	a := p.Activity()
+	for i, part := range p.Parts {
+		if part.Volume == 0 {
+			continue
+		}
+		mono := r.part(i, a, note, velocity, n)
+		gain := float64(part.Volume) / 127 * float64(p.PerfVol) / 127
+		pan := float64(part.Pan) / 127 * math.Pi / 2
+		left, right := gain*math.Cos(pan), gain*math.Sin(pan)
+		for k, x := range mono {
+			out[2*k] += float32(x * left)
+			out[2*k+1] += float32(x * right)
+		}
+	}
+	var peak float32
+	for _, x := range out {
+		if x > peak {
+			peak = x
+		} else if -x > peak {
+			peak = -x
+		}
+	}
+	if peak > 1 {
+		for k := range out {
+			out[k] /= peak
+		}
+	}
+	return out, nil
+}
+
+func (r *Renderer) Close() error {
+	return nil
+}
+
+// part renders part i of the patch as n mono samples.
+func (r *Renderer) part(i int, a midi.Activity, note, velocity int8, n int) []float64 {
+	p := r.patch
+	v := &p.Voices[i]
+	part := p.Parts[i]
+	key := float64(note) + float64(p.PerfNoteShift-24) + float64(part.NoteShift-24) + float64(v.NoteShift-24) +
+		(float64(part.Detune)-64)/100
+	base := 440 * math.Pow(2, (key-69)/12)
+	velo := float64(velocity) / 127
+
+	voiced := r.voiced(v, a.Voiced[i], base, velo, n)
+	unvoiced := r.unvoiced(v, a.Unvoiced[i], base, velo, n)
+	// 64 is an even balance, either end mutes the other side
+	balance := float64(part.VoicedUnvoicedBalance) / 127
+	vg, ug := math.Min(1, 2*(1-balance)), math.Min(1, 2*balance)
+	out := make([]float64, n)
+	for k := range out {
+		out[k] = vg*voiced[k] + ug*unvoiced[k]
+	}
+	if part.FilterSw != 0 {
+		r.filter(v, out)
+	}
+	return out
+}
+
+// level turns a 0-99 level parameter into an amplitude, 6dB per 8 steps
+// below full.
+func level(l int8) float64 {
+	if l <= 0 {
+		return 0
+	}
+	return math.Pow(2, (float64(l)-99)/8)
+}
+
+// egTime turns a 0-99 EG time into seconds, from 1ms to 10s.
+func egTime(t int8) float64 {
+	return 0.001 * math.Pow(10, float64(t)/99*4)
+}
+
+// eg is an envelope held on: it rises from 0 to level 1, then moves to
+// levels 2 and 3 and stays there. Level 4 is for the release, which a held
+// note never reaches.
+type eg struct {
+	levels [3]float64
+	ends   [3]float64 // seconds at which each segment is done
+}
+
+func newEG(lvl, tim [4]int8) (e eg) {
+	var t float64
+	for s := range e.levels {
+		e.levels[s] = float64(lvl[s]) / 99
+		t += egTime(tim[s])
+		e.ends[s] = t
+	}
+	return
+}
+
+func (e eg) at(t float64) float64 {
+	from, start := 0.0, 0.0
+	for s, end := range e.ends {
+		if t < end {
+			return from + (e.levels[s]-from)*(t-start)/(end-start)
+		}
+		from, start = e.levels[s], end
+	}
+	return e.levels[2]
+}
+
+// ratio is the frequency multiple set by a coarse and fine pair, coarse 0
+// meaning 0.5 as on the FM synths.
+func ratio(coarse, fine int8) float64 {
+	r := float64(coarse)
+	if coarse == 0 {
+		r = 0.5
+	}
+	return r * (1 + float64(fine)/128)
+}
+
+// fixed is the frequency of an op in fixed mode, coarse spanning about ten
+// octaves up from 14Hz.
+func fixed(coarse, fine int8, maxCoarse float64) float64 {
+	return 14 * math.Pow(2, float64(coarse)/maxCoarse*10+float64(fine)/127/3)
+}
+
+// veloScale applies a 0-14 velocity sensitivity, 7 being none.
+func veloScale(sense int8, velo float64) float64 {
+	return 1 + (velo-1)*float64(sense-7)/7
+}
+
+// voiced renders the FM half of voice v at base Hz.
+func (r *Renderer) voiced(v *midi.Voice, on [8]bool, base, velo float64, n int) []float64 {
+	type op struct {
+		on          bool
+		freq, amp   float64
+		env         eg
+		phase, last float64
+	}
+	var ops [8]op
+	for i, vp := range v.VoicedParams {
+		o := &ops[i]
+		o.on = on[i]
+		if vp.OscMode == 1 {
+			o.freq = fixed(vp.OscFreqCoarse, vp.OscFreqFine, 0x1f)
+		} else {
+			o.freq = base * ratio(vp.OscFreqCoarse, vp.OscFreqFine) * math.Pow(2, float64(vp.OscTranspose-24)/12)
+		}
+		o.freq *= math.Pow(2, float64(vp.OscFreqDetune-15)/1200)
+		o.amp = level(vp.LvlScalingTotal) * math.Max(0, veloScale(vp.AmpVeloSense, velo))
+		o.env = newEG(vp.EGLvl, vp.EGTime)
+	}
+
+	// ops 0, s, 2s... are carriers, each modulated by the ops up to the next
+	stacks := 1 + int(v.AlgoPreset)%8
+	size := (len(ops) + stacks - 1) / stacks
+	feedback := float64(v.VoicedFeedbackLvl) / 7 * math.Pi / 4
+
+	out := make([]float64, n)
+	dt := 1 / r.SampleRate
+	for k := range out {
+		t := float64(k) * dt
+		for c := 0; c < len(ops); c += size {
+			end := c + size - 1
+			if end >= len(ops) {
+				end = len(ops) - 1
+			}
+			var mod float64
+			for i := end; i >= c; i-- {
+				o := &ops[i]
+				if !o.on {
+					continue
+				}
+				in := mod
+				if i == end && c == 0 {
+					// the first stack's top op feeds back on itself
+					in += feedback * o.last
+				}
+				o.last = math.Sin(2*math.Pi*o.phase+in) * o.amp * o.env.at(t)
+				o.phase += o.freq * dt
+				o.phase -= math.Floor(o.phase)
+				if i == c {
+					out[k] += o.last
+				} else {
+					// modulation index of up to 4 radians
+					mod = 4 * o.last
+				}
+			}
+		}
+	}
+	return out
+}
+
+// unvoiced renders the formant half of voice v at base Hz.
+func (r *Renderer) unvoiced(v *midi.Voice, on [8]bool, base, velo float64, n int) []float64 {
+	type op struct {
+		amp    float64
+		env    eg
+		a1, a2 float64 // resonator coefficients
+		gain   float64
+		y1, y2 float64
+	}
+	var ops []op
+	for i, up := range v.UnvoicedParams {
+		if !on[i] {
+			continue
+		}
+		freq := base * ratio(up.FormantPitchCoarse, up.FormantPitchFine) * math.Pow(2, float64(up.FormantPitchTranspose-24)/12)
+		if up.FormantPitchMode != 0 {
+			freq = fixed(up.FormantPitchCoarse, up.FormantPitchFine, 0x15)
+		}
+		freq = math.Min(freq, r.SampleRate*0.45)
+		bw := 20 + float64(up.FormantShapeBandwidth)/99*2000
+		radius := math.Exp(-math.Pi * bw / r.SampleRate)
+		ops = append(ops, op{
+			amp:  level(up.Lvl) * math.Max(0, veloScale(up.AmpVeloSense, velo)),
+			env:  newEG(up.EGLvl, up.EGTime),
+			a1:   -2 * radius * math.Cos(2*math.Pi*freq/r.SampleRate),
+			a2:   radius * radius,
+			gain: 1 - radius,
+		})
+	}
+
+	out := make([]float64, n)
+	if len(ops) == 0 {
+		return out
+	}
+	dt := 1 / r.SampleRate
+	for k := range out {
+		x := 2*r.rng.Float64() - 1
+		t := float64(k) * dt
+		for i := range ops {
+			o := &ops[i]
+			y := o.gain*x - o.a1*o.y1 - o.a2*o.y2
+			o.y2, o.y1 = o.y1, y
+			out[k] += y * o.amp * o.env.at(t)
+		}
+	}
+	return out
+}
+
+// Filter types of VoiceCommon.FilterType
+const (
+	lpf24 = iota
+	lpf18
+	lpf12
+	hpf
+	bpf
+	bef
+)
+
+// filter runs buf through v's filter, a state variable filter at the
+// cutoff and resonance the voice sets, twice over for the steeper lowpasses.
+func (r *Renderer) filter(v *midi.Voice, buf []float64) {
+	cutoff := 20 * math.Pow(2, float64(v.FilterCutoffFreq)/127*10)
+	cutoff = math.Min(cutoff, r.SampleRate/6)
+	f := 2 * math.Sin(math.Pi*cutoff/r.SampleRate)
+	damp := 2 - 1.9*float64(v.FilterRez)/0x74
+	passes := 1
+	if v.FilterType == lpf24 || v.FilterType == lpf18 {
+		passes = 2
+	}
+	for pass := 0; pass < passes; pass++ {
+		var low, band float64
+		for k, x := range buf {
+			low += f * band
+			high := x - low - damp*band
+			band += f * high
+			switch v.FilterType {
+			case hpf:
+				buf[k] = high
+			case bpf:
+				buf[k] = band
+			case bef:
+				buf[k] = low + high
+			default:
+				buf[k] = low
+			}
+		}
+	}
+}
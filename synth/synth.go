@@ -0,0 +1,59 @@
+// Package synth plays patches and captures what they sound like. Renderer
+// is an approximate software rendering of an FS1r; a real one is played by
+// package synth/hardware, which needs portmidi and portaudio.
+package synth
+
+import (
+	"time"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+// Synth loads patches and plays notes on them.
+type Synth interface {
+	// Load makes p the patch the next notes are played with.
+	Load(p midi.Patch) error
+	// Play holds note for length and returns the interleaved stereo
+	// audio captured meanwhile.
+	Play(note, velocity int8, length time.Duration) ([]float32, error)
+	Close() error
+}
+
+// Mirror plays on s and sends the same patches and notes to out, so a run
+// scored on s can be replayed from out later. The note off is timestamped
+// length after the note on rather than waited for, which midi.FileOutput
+// honours. Close closes both.
+func Mirror(s Synth, out midi.Output) Synth {
+	return &mirror{s, out}
+}
+
+type mirror struct {
+	Synth
+	out midi.Output
+}
+
+func (m *mirror) Load(p midi.Patch) error {
+	if err := m.out.SendPatch(p); err != nil {
+		return err
+	}
+	return m.Synth.Load(p)
+}
+
+func (m *mirror) Play(note, velocity int8, length time.Duration) ([]float32, error) {
+	err := m.out.Write([]midi.Event{
+		{Status: 0x90, Data1: int64(note & 0x7f), Data2: int64(velocity & 0x7f)},
+		{Timestamp: length.Milliseconds(), Status: 0x80, Data1: int64(note & 0x7f), Data2: int64(velocity & 0x7f)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.Synth.Play(note, velocity, length)
+}
+
+func (m *mirror) Close() error {
+	err := m.out.Close()
+	if cerr := m.Synth.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package synth
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mkb218/fevolver/midi"
+)
+
+func TestRenderer(t *testing.T) {
+	p := midi.RandomPatch()
+	p.PerfVol = 127
+	for i := range p.Parts {
+		p.Parts[i].Volume = 0
+	}
+	r := NewRenderer(8000)
+	r.Load(p)
+	buf, err := r.Play(60, 100, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != 2*4000 {
+		t.Fatal("rendered", len(buf), "samples, want 8000")
+	}
+	for _, x := range buf {
+		if x != 0 {
+			t.Fatal("muted parts made a sound")
+		}
+	}
+
+	p.Parts[0].Volume = 100
+	p.Parts[0].VoicedUnvoicedBalance = 0
+	p.Parts[0].FilterSw = 0
+	v := &p.Voices[0]
+	v.FseqVoicedOpSwitchLo = 1
+	v.VoicedParams[0].LvlScalingTotal = 99
+	v.VoicedParams[0].EGLvl = [4]int8{99, 99, 99, 0}
+	v.VoicedParams[0].EGTime = [4]int8{0, 0, 0, 0}
+	r.Load(p)
+	buf, _ = r.Play(60, 100, 500*time.Millisecond)
+	var loud bool
+	for _, x := range buf {
+		if x > 1 || x < -1 {
+			t.Fatal("sample", x, "out of range")
+		}
+		if x > 0.1 || x < -0.1 {
+			loud = true
+		}
+	}
+	if !loud {
+		t.Error("a carrier at full level is silent")
+	}
+}
+
+func TestRendererSeed(t *testing.T) {
+	p := midi.RandomPatch()
+	p.PerfVol = 127
+	for i := range p.Parts {
+		p.Parts[i].Volume = 0
+	}
+	p.Parts[0].Volume = 100
+	p.Parts[0].VoicedUnvoicedBalance = 127
+	p.Parts[0].FilterSw = 0
+	v := &p.Voices[0]
+	v.FseqUnvoicedOpSwitchLo = 1
+	v.UnvoicedParams[0].Lvl = 99
+	v.UnvoicedParams[0].EGLvl = [4]int8{99, 99, 99, 0}
+	v.UnvoicedParams[0].EGTime = [4]int8{0, 0, 0, 0}
+
+	render := func(r *Renderer) []float32 {
+		r.Load(p)
+		buf, err := r.Play(60, 100, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+	r := NewRenderer(8000)
+	first, again := render(r), render(r)
+	other := NewRenderer(8000)
+	other.Seed = 2
+	reseeded := render(other)
+	var differs, loud bool
+	for k := range first {
+		if first[k] != again[k] {
+			t.Fatal("sample", k, "changed between plays of the same patch")
+		}
+		if first[k] != reseeded[k] {
+			differs = true
+		}
+		if first[k] != 0 {
+			loud = true
+		}
+	}
+	if !loud {
+		t.Fatal("an unvoiced op at full level is silent")
+	}
+	if !differs {
+		t.Error("another seed made the same noise")
+	}
+}
+
+func TestMirror(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out.mid")
+	out, err := midi.NewFileOutput(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := midi.RandomPatch()
+	s := Mirror(NewRenderer(8000), out)
+	if err = s.Load(p); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := s.Play(60, 100, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != 2*4000 {
+		t.Error("rendered", len(buf), "samples, want 8000")
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(file, p.Msgs()[0][1:]) || !bytes.Contains(file, []byte{0x90, 60, 100}) {
+		t.Error("the file is missing the sysex or the note on")
+	}
+	// the note off comes 500 ticks after the note on, not as it was played
+	if !bytes.Contains(file, []byte{0x83, 0x74, 0x80, 60, 100}) {
+		t.Error("the note off isn't held for the note's length")
+	}
+}